@@ -12,8 +12,10 @@ import (
 // Config 应用配置结构
 type Config struct {
 	Server struct {
-		Host string `yaml:"host"`
-		Port int    `yaml:"port"`
+		Host           string   `yaml:"host"`
+		Port           int      `yaml:"port"`
+		TrustedProxies []string `yaml:"trustedProxies"`
+		ProxyProtocol  bool     `yaml:"proxyProtocol"`
 	} `yaml:"server"`
 
 	Proxy struct {
@@ -21,26 +23,176 @@ type Config struct {
 		TransferTimeout  int   `yaml:"transferTimeout"`
 		BufferSize       int   `yaml:"bufferSize"`
 		ChunkedThreshold int64 `yaml:"chunkedThreshold"`
+
+		AdaptiveBuffer struct {
+			Enabled bool `yaml:"enabled"`
+			MinSize int  `yaml:"minSize"`
+			MaxSize int  `yaml:"maxSize"`
+		} `yaml:"adaptiveBuffer"`
+
+		Cache struct {
+			Enabled    bool           `yaml:"enabled"`
+			Dir        string         `yaml:"dir"`
+			MaxSize    int64          `yaml:"maxSize"`
+			DefaultTTL int            `yaml:"defaultTTL"`
+			TTLRules   []CacheTTLRule `yaml:"ttlRules"`
+		} `yaml:"cache"`
+
+		// 返回给客户端的缓存相关响应头
+		CacheHeaders struct {
+			Mode  string            `yaml:"mode"`  // passthrough转发上游的缓存头，noCache禁止客户端缓存
+			Rules []CacheHeaderRule `yaml:"rules"` // 按目标主机和路径覆盖Cache-Control，按顺序匹配第一条
+		} `yaml:"cacheHeaders"`
+
+		Coalescing struct {
+			Enabled  bool   `yaml:"enabled"`
+			SpoolDir string `yaml:"spoolDir"`
+		} `yaml:"coalescing"`
+
+		Segmented struct {
+			Enabled     bool  `yaml:"enabled"`
+			SegmentSize int64 `yaml:"segmentSize"`
+			Concurrency int   `yaml:"concurrency"`
+		} `yaml:"segmented"`
+
+		Resume struct {
+			Enabled    bool `yaml:"enabled"`
+			MaxRetries int  `yaml:"maxRetries"`
+			Backoff    int  `yaml:"backoff"`
+		} `yaml:"resume"`
 	} `yaml:"proxy"`
 
 	Security struct {
 		RateLimiting struct {
-			Enabled           bool `yaml:"enabled"`
-			RequestsPerMinute int  `yaml:"requestsPerMinute"`
+			Enabled           bool     `yaml:"enabled"`
+			Algorithm         string   `yaml:"algorithm"`
+			RequestsPerMinute int      `yaml:"requestsPerMinute"`
+			Burst             int      `yaml:"burst"`
+			ExemptPaths       []string `yaml:"exemptPaths"`
 		} `yaml:"rateLimiting"`
 		PrivateIPBlocking bool `yaml:"privateIPBlocking"`
+
+		Concurrency struct {
+			PerClient    int    `yaml:"perClient"`
+			PerHost      int    `yaml:"perHost"`
+			Global       int    `yaml:"global"`
+			Mode         string `yaml:"mode"`
+			QueueTimeout int    `yaml:"queueTimeout"`
+		} `yaml:"concurrency"`
+
+		Bandwidth struct {
+			PerClient  int64 `yaml:"perClient"`
+			Global     int64 `yaml:"global"`
+			DailyQuota int64 `yaml:"dailyQuota"`
+		} `yaml:"bandwidth"`
+
+		Network struct {
+			DenyCIDRs  []string `yaml:"denyCIDRs"`
+			AllowCIDRs []string `yaml:"allowCIDRs"`
+		} `yaml:"network"`
+
+		Hosts struct {
+			Allow []HostRule `yaml:"allow"`
+			Deny  []HostRule `yaml:"deny"`
+		} `yaml:"hosts"`
+
+		Redirects struct {
+			MaxHops int `yaml:"maxHops"`
+		} `yaml:"redirects"`
 	} `yaml:"security"`
 
 	Headers struct {
-		RemoveProxyHeaders     bool   `yaml:"removeProxyHeaders"`
-		RemoveSensitiveHeaders bool   `yaml:"removeSensitiveHeaders"`
-		NodeID                 string `yaml:"nodeId"`
+		RemoveProxyHeaders     bool          `yaml:"removeProxyHeaders"`
+		RemoveSensitiveHeaders bool          `yaml:"removeSensitiveHeaders"`
+		NodeID                 string        `yaml:"nodeId"`
+		Request                HeaderActions `yaml:"request"`  // 对所有上游请求生效
+		Response               HeaderActions `yaml:"response"` // 对所有响应生效
+		Rules                  []HeaderRule  `yaml:"rules"`    // 按目标主机生效，在全局修改之后依次应用
 	} `yaml:"headers"`
 
+	Metrics struct {
+		Enabled bool   `yaml:"enabled"`
+		Token   string `yaml:"token"`
+	} `yaml:"metrics"`
+
+	Admin struct {
+		Enabled bool   `yaml:"enabled"`
+		Token   string `yaml:"token"`
+	} `yaml:"admin"`
+
+	Tracing struct {
+		Enabled     bool              `yaml:"enabled"`
+		Exporter    string            `yaml:"exporter"`
+		Endpoint    string            `yaml:"endpoint"`
+		Headers     map[string]string `yaml:"headers"`
+		ServiceName string            `yaml:"serviceName"`
+		SampleRatio float64           `yaml:"sampleRatio"`
+		Timeout     int               `yaml:"timeout"`
+	} `yaml:"tracing"`
+
 	Logging struct {
 		Level  string `yaml:"level"`
 		Format string `yaml:"format"`
 	} `yaml:"logging"`
+
+	Reload struct {
+		Watch    bool `yaml:"watch"`
+		Interval int  `yaml:"interval"`
+	} `yaml:"reload"`
+
+	// 每个配置项的来源和解析配置文件时发现的问题，由Validate使用
+	locations map[string]string
+	problems  []Problem
+}
+
+// CacheTTLRule 按主机设置缓存有效期
+// Host 以"."开头时匹配该域名及其所有子域名，支持"*"通配符
+type CacheTTLRule struct {
+	Host string `yaml:"host"`
+	TTL  int    `yaml:"ttl"` // 秒，0表示每次都向上游验证
+}
+
+// CacheHeaderRule 对匹配的目标主机和路径设置Cache-Control
+// Host的格式与HostRule相同，Path中的"*"匹配包括"/"在内的任意字符，也支持"regex:"开头的正则表达式，
+// 两者都设置时需要同时匹配，为空时匹配所有
+type CacheHeaderRule struct {
+	Name         string `yaml:"name"`
+	Host         string `yaml:"host"`
+	Path         string `yaml:"path"`
+	CacheControl string `yaml:"cacheControl"`
+}
+
+// 缓存响应头的处理模式
+const (
+	CacheHeadersPassthrough = "passthrough"
+	CacheHeadersNoCache     = "noCache"
+)
+
+// HeaderActions 对请求头或响应头的修改，依次执行删除、设置和追加
+// 值中可以使用模板变量{client_ip}、{request_id}、{node_id}和{host}
+type HeaderActions struct {
+	Set    map[string]string `yaml:"set"`    // 设置头，值为空时删除该头
+	Add    map[string]string `yaml:"add"`    // 追加头，保留已有的值
+	Remove []string          `yaml:"remove"` // 删除头
+	Pass   []string          `yaml:"pass"`   // 不受removeProxyHeaders和removeSensitiveHeaders影响，原样转发的头
+}
+
+// HeaderRule 对匹配的目标主机生效的头部规则，Pattern的格式与HostRule相同
+type HeaderRule struct {
+	Name     string        `yaml:"name"`
+	Pattern  string        `yaml:"pattern"`
+	Request  HeaderActions `yaml:"request"`
+	Response HeaderActions `yaml:"response"`
+}
+
+// HeaderTemplateVars 头部值中可以使用的模板变量
+var HeaderTemplateVars = []string{"client_ip", "request_id", "node_id", "host"}
+
+// HostRule 目标主机的允许或禁止规则
+// Pattern 支持完整域名、以"."开头的域名后缀、"*"通配符以及"regex:"开头的正则表达式
+type HostRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
 }
 
 // DefaultConfig 返回默认配置
@@ -57,30 +209,95 @@ func DefaultConfig() *Config {
 	cfg.Proxy.BufferSize = 32 * 1024
 	cfg.Proxy.ChunkedThreshold = 100 * 1024 * 1024 // 100MB
 
+	// 自适应缓冲区（默认关闭，使用固定的bufferSize）
+	cfg.Proxy.AdaptiveBuffer.Enabled = false
+	cfg.Proxy.AdaptiveBuffer.MinSize = 4 * 1024
+	cfg.Proxy.AdaptiveBuffer.MaxSize = 1024 * 1024
+
+	// 磁盘缓存配置（默认关闭）
+	cfg.Proxy.Cache.Enabled = false
+	cfg.Proxy.Cache.Dir = "cache"
+	cfg.Proxy.Cache.MaxSize = 10 * 1024 * 1024 * 1024 // 10GB
+	cfg.Proxy.Cache.DefaultTTL = 3600
+
+	// 缓存响应头，默认转发上游的Cache-Control、ETag和Last-Modified
+	cfg.Proxy.CacheHeaders.Mode = CacheHeadersPassthrough
+
+	// 并发下载合并配置（默认关闭）
+	cfg.Proxy.Coalescing.Enabled = false
+	cfg.Proxy.Coalescing.SpoolDir = ""
+
+	// 多连接分段下载配置（默认关闭）
+	cfg.Proxy.Segmented.Enabled = false
+	cfg.Proxy.Segmented.SegmentSize = 8 * 1024 * 1024 // 8MB
+	cfg.Proxy.Segmented.Concurrency = 4
+
+	// 断点续传配置
+	cfg.Proxy.Resume.Enabled = true
+	cfg.Proxy.Resume.MaxRetries = 3
+	cfg.Proxy.Resume.Backoff = 500 // 毫秒
+
 	// 安全配置
 	cfg.Security.RateLimiting.Enabled = true
+	cfg.Security.RateLimiting.Algorithm = "slidingWindow"
 	cfg.Security.RateLimiting.RequestsPerMinute = 60
+	cfg.Security.RateLimiting.Burst = 10
+	cfg.Security.RateLimiting.ExemptPaths = []string{"/health", "/static/", "/metrics"}
+	cfg.Security.Concurrency.Mode = "reject"
+	cfg.Security.Concurrency.QueueTimeout = 30
 	cfg.Security.PrivateIPBlocking = true
+	cfg.Security.Redirects.MaxHops = 10
 
 	// 头部配置
 	cfg.Headers.RemoveProxyHeaders = true
 	cfg.Headers.RemoveSensitiveHeaders = true
 	cfg.Headers.NodeID = "node1"
+	cfg.Headers.Response.Set = map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-XSS-Protection":          "1; mode=block",
+		"X-Frame-Options":           "DENY",
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"Content-Security-Policy":   "default-src 'self'",
+	}
+
+	// 监控指标
+	cfg.Metrics.Enabled = true
+
+	// 管理接口（默认关闭）
+	cfg.Admin.Enabled = false
+
+	// 链路追踪（默认关闭）
+	cfg.Tracing.Enabled = false
+	cfg.Tracing.Exporter = "otlp"
+	cfg.Tracing.Endpoint = "http://localhost:4318/v1/traces"
+	cfg.Tracing.ServiceName = "dl-proxy"
+	cfg.Tracing.SampleRatio = 1.0
+	cfg.Tracing.Timeout = 10
 
 	// 日志配置
 	cfg.Logging.Level = "info"
 	cfg.Logging.Format = "text"
 
+	// 配置热加载
+	cfg.Reload.Watch = true
+	cfg.Reload.Interval = 5
+
 	return cfg
 }
 
 // LoadConfig 从文件加载配置
-func LoadConfig(filename string) (*Config, error) {
+// 配置文件不存在时使用默认配置，writeDefault为true时同时将默认配置写入文件
+func LoadConfig(filename string, writeDefault bool) (*Config, error) {
 	// 使用默认配置
 	config := DefaultConfig()
 
 	// 如果配置文件不存在，创建默认配置文件
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		// 只读挂载等场景下不写入文件
+		if !writeDefault {
+			return config, nil
+		}
+
 		// 确保目录存在
 		dir := filepath.Dir(filename)
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -95,14 +312,22 @@ func LoadConfig(filename string) (*Config, error) {
 		return config, nil
 	}
 
+	return ReadConfig(filename)
+}
+
+// ReadConfig 读取已存在的配置文件，未设置的字段使用默认值
+// 与LoadConfig不同，文件不存在时返回错误而不是创建默认配置，用于重新加载配置
+func ReadConfig(filename string) (*Config, error) {
+	config := DefaultConfig()
+
 	// 读取配置文件
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("读取配置文件失败: %v", err)
 	}
 
-	// 解析YAML
-	if err := yaml.Unmarshal(data, config); err != nil {
+	// 解析YAML，未知的配置项和类型错误由Validate报告
+	if err := config.decode(filename, data); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %v", err)
 	}
 
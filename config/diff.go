@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change 一个配置项的变化
+type Change struct {
+	Field string // 以yaml字段名表示的路径，如security.rateLimiting.burst
+	Old   string
+	New   string
+}
+
+// maskedValue 敏感配置项在变化记录中的显示值
+const maskedValue = "******"
+
+// Diff 比较两份配置，返回所有发生变化的配置项
+// 令牌和导出请求头等敏感内容不输出原值
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	newFields := fields(new)
+	for i, f := range fields(old) {
+		oldValue, newValue := f.value, newFields[i].value
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+		change := Change{Field: f.path, Old: formatValue(oldValue), New: formatValue(newValue)}
+		if isSensitiveField(f.path) {
+			change.Old, change.New = maskedValue, maskedValue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// formatValue 格式化配置值，切片中的结构体按字段名输出
+func formatValue(v reflect.Value) string {
+	return fmt.Sprintf("%+v", v.Interface())
+}
+
+// isSensitiveField 判断配置项是否包含凭据，头部策略设置的值可能是访问令牌
+func isSensitiveField(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.Contains(lower, "token") || lower == "tracing.headers" ||
+		lower == "headers.rules" || strings.HasPrefix(lower, "headers.") &&
+		(strings.HasSuffix(lower, ".set") || strings.HasSuffix(lower, ".add"))
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 覆盖配置项的环境变量前缀
+const EnvPrefix = "DLPROXY_"
+
+// 对应-config和-no-write-default命令行参数的环境变量，不属于配置项
+const (
+	EnvConfigFile     = EnvPrefix + "CONFIG"
+	EnvNoWriteDefault = EnvPrefix + "NO_WRITE_DEFAULT"
+)
+
+// field 配置中的一个叶子配置项
+type field struct {
+	path  string // yaml字段路径，如security.rateLimiting.burst，同时作为命令行参数名
+	env   string // 环境变量名，如DLPROXY_SECURITY_RATE_LIMITING_BURST
+	value reflect.Value
+}
+
+// fields 按声明顺序列出配置中的所有叶子配置项
+func fields(cfg *Config) []field {
+	var result []field
+	walkFields("", reflect.ValueOf(cfg).Elem(), func(path string, v reflect.Value) {
+		result = append(result, field{path: path, env: envName(path), value: v})
+	})
+	return result
+}
+
+// walkFields 按yaml字段名遍历结构体，对每个非结构体的字段调用fn
+func walkFields(path string, v reflect.Value, fn func(path string, v reflect.Value)) {
+	if v.Kind() != reflect.Struct {
+		fn(path, v)
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+		walkFields(name, v.Field(i), fn)
+	}
+}
+
+// envName 将字段路径转换为环境变量名，驼峰命名按单词拆分，连续大写视为一个单词
+// 如security.network.allowCIDRs对应DLPROXY_SECURITY_NETWORK_ALLOW_CIDRS
+func envName(path string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, part := range strings.Split(path, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		runes := []rune(part)
+		for j, r := range runes {
+			if j > 0 && unicode.IsUpper(r) && wordStart(runes, j) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// wordStart 判断大写字母是否为新单词的开头
+// 前一个字母小写时是新单词，如rate|Limiting；连续大写后接小写时最后一个大写字母开始新单词，
+// 如private|IP|Blocking，但结尾的复数s属于前面的缩写，如allow|CIDRs
+func wordStart(runes []rune, i int) bool {
+	if unicode.IsLower(runes[i-1]) {
+		return true
+	}
+	if i+1 >= len(runes) || !unicode.IsLower(runes[i+1]) {
+		return false
+	}
+	return !(runes[i+1] == 's' && i+2 == len(runes))
+}
+
+// typeName 命令行帮助中显示的参数类型
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "int"
+	case reflect.Float64:
+		return "float"
+	case reflect.Slice:
+		return "list"
+	case reflect.Map:
+		return "map"
+	default:
+		return t.Kind().String()
+	}
+}
+
+// setField 将字符串解析为字段对应的类型并赋值
+// 列表使用逗号分隔，如a,b,c；映射使用逗号分隔的key=value；
+// 以[或{开头的值以及结构体列表按YAML解析，如[{name: gh, pattern: "*.github.com"}]
+func setField(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("无效的布尔值: %s", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return fmt.Errorf("无效的整数: %s", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("无效的数值: %s", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice, reflect.Map:
+		trimmed := strings.TrimSpace(s)
+		structured := strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{")
+		switch {
+		case !structured && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+			list := []string{}
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+		case !structured && v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.String:
+			m := reflect.MakeMap(v.Type())
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				key, value, ok := strings.Cut(item, "=")
+				if !ok {
+					return fmt.Errorf("无效的键值对: %s，应为key=value", item)
+				}
+				m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), reflect.ValueOf(strings.TrimSpace(value)))
+			}
+			v.Set(m)
+		default:
+			ptr := reflect.New(v.Type())
+			if err := yaml.Unmarshal([]byte(s), ptr.Interface()); err != nil {
+				return fmt.Errorf("解析YAML失败: %v", err)
+			}
+			v.Set(ptr.Elem())
+		}
+	default:
+		return fmt.Errorf("不支持的配置类型: %s", v.Type())
+	}
+	return nil
+}
+
+// Overrides 来自环境变量和命令行参数的配置覆盖
+// 优先级从低到高依次为：默认值、配置文件、环境变量、命令行参数
+type Overrides struct {
+	env   []override
+	flags []override
+}
+
+// override 一项配置覆盖
+type override struct {
+	path   string
+	value  string
+	source string // 来源，用于错误信息
+}
+
+// LoadEnv 读取以DLPROXY_开头的环境变量，返回不对应任何配置项的变量名，由调用方给出警告
+// 未知的变量不能视为错误：Kubernetes会为名为dlproxy的Service注入DLPROXY_PORT、DLPROXY_SERVICE_HOST等变量
+func (o *Overrides) LoadEnv(environ []string) (unknown []string) {
+	paths := make(map[string]string)
+	for _, f := range fields(DefaultConfig()) {
+		paths[f.env] = f.path
+	}
+
+	o.env = nil
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || name == EnvConfigFile || name == EnvNoWriteDefault {
+			continue
+		}
+		path, ok := paths[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		o.env = append(o.env, override{path: path, value: value, source: "环境变量 " + name})
+	}
+	return unknown
+}
+
+// RegisterFlags 为每个配置项注册以字段路径命名的命令行参数，如-server.port=8080
+func (o *Overrides) RegisterFlags(fs *flag.FlagSet) {
+	for _, f := range fields(DefaultConfig()) {
+		fs.Var(&flagValue{overrides: o, path: f.path, typ: f.value.Type()}, f.path,
+			fmt.Sprintf("覆盖配置项%s，类型为`%s`，也可以使用环境变量%s", f.path, typeName(f.value.Type()), f.env))
+	}
+}
+
+// Apply 依次应用环境变量和命令行参数，后应用的优先
+func (o *Overrides) Apply(cfg *Config) error {
+	byPath := make(map[string]reflect.Value)
+	for _, f := range fields(cfg) {
+		byPath[f.path] = f.value
+	}
+
+	for _, list := range [][]override{o.env, o.flags} {
+		for _, ov := range list {
+			if err := setField(byPath[ov.path], ov.value); err != nil {
+				return fmt.Errorf("%s: %v", ov.source, err)
+			}
+			cfg.setLocation(ov.path, ov.source)
+		}
+	}
+	return nil
+}
+
+// flagValue 实现flag.Value，解析时只校验格式，加载配置后再应用
+type flagValue struct {
+	overrides *Overrides
+	path      string
+	typ       reflect.Type
+	value     string
+}
+
+// String 实现flag.Value接口
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+// Set 实现flag.Value接口
+func (f *flagValue) Set(s string) error {
+	if err := setField(reflect.New(f.typ).Elem(), s); err != nil {
+		return err
+	}
+	f.value = s
+	f.overrides.flags = append(f.overrides.flags, override{path: f.path, value: s, source: "命令行参数 -" + f.path})
+	return nil
+}
+
+// IsBoolFlag 布尔配置项可以省略值，如-metrics.enabled
+func (f *flagValue) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
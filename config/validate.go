@@ -0,0 +1,546 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/yourusername/proxy-service/tracing"
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// Problem 配置中的一个问题
+type Problem struct {
+	Field    string // 配置项路径，如security.rateLimiting.burst
+	Location string // 配置项的来源，如config.yaml:12或环境变量 DLPROXY_SERVER_PORT，使用默认值时为空
+	Message  string
+}
+
+// String 按"来源: 配置项: 问题"的格式输出
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Location != "" {
+		b.WriteString(p.Location + ": ")
+	}
+	if p.Field != "" {
+		b.WriteString(p.Field + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError 配置校验失败，包含发现的所有问题
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error 实现error接口，每个问题占一行
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return fmt.Sprintf("配置有%d个问题:\n  %s", len(e.Problems), strings.Join(lines, "\n  "))
+}
+
+// decode 解析配置文件，记录每个配置项所在的行
+// 未知的配置项和类型错误不会中断解析，而是记录下来由Validate一并返回
+func (c *Config) decode(filename string, data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	c.locations = make(map[string]string)
+	if len(root.Content) == 0 {
+		return nil
+	}
+	doc := root.Content[0]
+
+	c.checkNode(filename, "", doc, reflect.TypeOf(c).Elem())
+	if err := doc.Decode(c); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		for _, msg := range typeErr.Errors {
+			p := Problem{Message: "类型错误: " + msg}
+			if line, rest, ok := strings.Cut(strings.TrimPrefix(msg, "line "), ": "); ok {
+				if _, err := strconv.Atoi(line); err == nil {
+					p.Location = filename + ":" + line
+					p.Field = c.fieldAt(p.Location)
+					p.Message = "类型错误: " + rest
+				}
+			}
+			c.problems = append(c.problems, p)
+		}
+	}
+	return nil
+}
+
+// checkNode 对照配置结构检查YAML节点，记录配置项所在的行和未知的配置项
+func (c *Config) checkNode(filename, prefix string, node *yaml.Node, t reflect.Type) {
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			name := key.Value
+			if prefix != "" {
+				name = prefix + "." + key.Value
+			}
+			location := fmt.Sprintf("%s:%d", filename, key.Line)
+
+			sf, ok := fieldByName(t, key.Value)
+			if !ok {
+				c.problems = append(c.problems, Problem{
+					Field:    name,
+					Location: location,
+					Message:  unknownFieldMessage(t, key.Value),
+				})
+				continue
+			}
+			c.locations[name] = location
+			c.checkNode(filename, name, value, sf.Type)
+		}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			name := fmt.Sprintf("%s[%d]", prefix, i)
+			c.locations[name] = fmt.Sprintf("%s:%d", filename, item.Line)
+			c.checkNode(filename, name, item, t.Elem())
+		}
+	}
+}
+
+// fieldAt 查找位于指定位置的配置项，同一行有多个时返回层级最深的
+func (c *Config) fieldAt(location string) string {
+	var field string
+	for name, loc := range c.locations {
+		if loc == location && len(name) > len(field) {
+			field = name
+		}
+	}
+	return field
+}
+
+// fieldByName 按yaml字段名查找结构体字段
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if tag := strings.Split(sf.Tag.Get("yaml"), ",")[0]; tag != "" && tag != "-" && tag == name {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// unknownFieldMessage 生成未知配置项的提示，拼写相近时给出正确的名称
+func unknownFieldMessage(t reflect.Type, name string) string {
+	best, bestDist := "", 3
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if strings.EqualFold(tag, name) {
+			return fmt.Sprintf("未知的配置项，是否应为%s（区分大小写）", tag)
+		}
+		if d := editDistance(strings.ToLower(tag), strings.ToLower(name)); d < bestDist {
+			best, bestDist = tag, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("未知的配置项，是否应为%s", best)
+	}
+	return "未知的配置项"
+}
+
+// editDistance 计算两个字符串的编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// setLocation 记录配置项的来源，覆盖整个列表时清除其中元素的来源
+func (c *Config) setLocation(field, location string) {
+	if c.locations == nil {
+		c.locations = make(map[string]string)
+	}
+	for name := range c.locations {
+		if strings.HasPrefix(name, field+".") || strings.HasPrefix(name, field+"[") {
+			delete(c.locations, name)
+		}
+	}
+	c.locations[field] = location
+}
+
+// location 返回配置项的来源，没有记录时使用所在列表元素或上级配置项的来源
+func (c *Config) location(field string) string {
+	for field != "" {
+		if location, ok := c.locations[field]; ok {
+			return location
+		}
+		field = field[:max(strings.LastIndexAny(field, ".["), 0)]
+	}
+	return ""
+}
+
+const (
+	maxBufferSize  = 64 * 1024 * 1024 // 单个传输缓冲区的大小上限
+	maxSegmentSize = 64 * 1024 * 1024 // 分段大小的上限，每个分段都会完整缓存在内存中
+)
+
+const (
+	maxResumeRetries = 100   // 续传次数上限
+	maxResumeBackoff = 30000 // 续传初始等待时间的上限(毫秒)，与proxy中每次等待时间的上限相同
+)
+
+// validator 收集校验发现的问题
+type validator struct {
+	cfg      *Config
+	problems []Problem
+}
+
+// add 记录一个问题
+func (v *validator) add(field, format string, args ...any) {
+	v.problems = append(v.problems, Problem{
+		Field:    field,
+		Location: v.cfg.location(field),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// positive 检查数值大于0
+func (v *validator) positive(field string, n int64) {
+	if n <= 0 {
+		v.add(field, "必须大于0，当前为%d", n)
+	}
+}
+
+// nonNegative 检查数值不小于0
+func (v *validator) nonNegative(field string, n int64) {
+	if n < 0 {
+		v.add(field, "不能为负数，当前为%d", n)
+	}
+}
+
+// oneOf 检查取值是否在允许的范围内
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	v.add(field, "无效的取值%q，可选值为%s", value, strings.Join(names, ", "))
+}
+
+// cidrs 检查地址段列表
+func (v *validator) cidrs(field string, list []string) {
+	for i, cidr := range list {
+		if _, err := utils.ParsePrefixes([]string{cidr}); err != nil {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+		}
+	}
+}
+
+// hostRules 检查主机规则的匹配模式
+func (v *validator) hostRules(field string, rules []HostRule) {
+	for i, rule := range rules {
+		v.hostPattern(fmt.Sprintf("%s[%d].pattern", field, i), rule.Pattern)
+	}
+}
+
+// hostPattern 检查主机匹配模式，格式与proxy中的主机规则相同
+func (v *validator) hostPattern(field, pattern string) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		// 与proxy中相同，正则表达式锚定到完整的主机名
+		if _, err := regexp.Compile("^(?:" + expr + ")$"); err != nil {
+			v.add(field, "无效的正则表达式: %v", err)
+		}
+	} else if pattern == "" {
+		v.add(field, "不能为空")
+	} else if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+		v.add(field, "无效的通配符: %v", err)
+	}
+}
+
+// pathPattern 检查路径匹配模式，为空时匹配所有路径
+func (v *validator) pathPattern(field, pattern string) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			v.add(field, "无效的正则表达式: %v", err)
+		}
+	} else if pattern != "" && !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "*") {
+		v.add(field, "必须以/或*开头，当前为%q", pattern)
+	}
+}
+
+var (
+	// headerNameRE HTTP头名称允许的字符
+	headerNameRE = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+	// templateVarRE 头部值中的模板变量
+	templateVarRE = regexp.MustCompile(`\{([a-z_]+)\}`)
+)
+
+// protectedHeaders 由HTTP协议处理，不能通过头部策略修改的头
+var protectedHeaders = []string{"Host", "Content-Length", "Transfer-Encoding", "Connection"}
+
+// headerActions 检查头部修改中的头名称和值模板
+func (v *validator) headerActions(field string, actions HeaderActions) {
+	checkName := func(name, field string) bool {
+		if !headerNameRE.MatchString(name) {
+			v.add(field, "无效的头名称%q", name)
+			return false
+		}
+		for _, p := range protectedHeaders {
+			if strings.EqualFold(name, p) {
+				v.add(field, "不能修改%s头", p)
+				return false
+			}
+		}
+		return true
+	}
+	checkValues := func(kind string, values map[string]string) {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := fmt.Sprintf("%s.%s.%s", field, kind, name)
+			if !checkName(name, f) {
+				continue
+			}
+			value := values[name]
+			if strings.ContainsAny(value, "\r\n") {
+				v.add(f, "值不能包含换行")
+			}
+			for _, m := range templateVarRE.FindAllStringSubmatch(value, -1) {
+				known := false
+				for _, name := range HeaderTemplateVars {
+					known = known || m[1] == name
+				}
+				if !known {
+					v.add(f, "未知的模板变量%s，可用的变量为{%s}", m[0], strings.Join(HeaderTemplateVars, "}, {"))
+				}
+			}
+		}
+	}
+	checkValues("set", actions.Set)
+	checkValues("add", actions.Add)
+	for i, name := range actions.Remove {
+		checkName(name, fmt.Sprintf("%s.remove[%d]", field, i))
+	}
+	for i, name := range actions.Pass {
+		checkName(name, fmt.Sprintf("%s.pass[%d]", field, i))
+	}
+}
+
+// atMost 检查单项限制不超过全局限制，全局限制为0表示不限制
+func (v *validator) atMost(field string, n int64, globalField string, global int64) {
+	if n > 0 && global > 0 && n > global {
+		v.add(field, "不能大于%s(%d)，当前为%d", globalField, global, n)
+	}
+}
+
+// Validate 校验配置的取值范围和配置项之间的一致性
+// 配置无效时返回*ValidationError，其中包含解析配置文件时发现的未知配置项、类型错误和所有校验问题
+func (c *Config) Validate() error {
+	v := &validator{cfg: c, problems: append([]Problem(nil), c.problems...)}
+
+	// 服务器
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		v.add("server.port", "必须在1-65535之间，当前为%d", c.Server.Port)
+	}
+	v.cidrs("server.trustedProxies", c.Server.TrustedProxies)
+
+	// 代理
+	v.positive("proxy.connectTimeout", int64(c.Proxy.ConnectTimeout))
+	v.positive("proxy.transferTimeout", int64(c.Proxy.TransferTimeout))
+	if c.Proxy.ConnectTimeout > 0 && c.Proxy.TransferTimeout > 0 && c.Proxy.TransferTimeout < c.Proxy.ConnectTimeout {
+		v.add("proxy.transferTimeout", "不能小于proxy.connectTimeout(%d)，当前为%d", c.Proxy.ConnectTimeout, c.Proxy.TransferTimeout)
+	}
+	v.positive("proxy.bufferSize", int64(c.Proxy.BufferSize))
+	if c.Proxy.BufferSize > maxBufferSize {
+		v.add("proxy.bufferSize", "不能超过%d，当前为%d", maxBufferSize, c.Proxy.BufferSize)
+	}
+	if ab := c.Proxy.AdaptiveBuffer; ab.Enabled {
+		v.positive("proxy.adaptiveBuffer.minSize", int64(ab.MinSize))
+		if ab.MaxSize < ab.MinSize {
+			v.add("proxy.adaptiveBuffer.maxSize", "不能小于proxy.adaptiveBuffer.minSize(%d)，当前为%d", ab.MinSize, ab.MaxSize)
+		} else if ab.MaxSize > maxBufferSize {
+			v.add("proxy.adaptiveBuffer.maxSize", "不能超过%d，当前为%d", maxBufferSize, ab.MaxSize)
+		}
+	}
+	v.nonNegative("proxy.chunkedThreshold", c.Proxy.ChunkedThreshold)
+
+	if cache := c.Proxy.Cache; cache.Enabled {
+		if cache.Dir == "" {
+			v.add("proxy.cache.dir", "启用缓存时不能为空")
+		}
+		v.positive("proxy.cache.maxSize", cache.MaxSize)
+	}
+	v.nonNegative("proxy.cache.defaultTTL", int64(c.Proxy.Cache.DefaultTTL))
+	for i, rule := range c.Proxy.Cache.TTLRules {
+		if rule.Host == "" {
+			v.add(fmt.Sprintf("proxy.cache.ttlRules[%d].host", i), "不能为空")
+		}
+		v.nonNegative(fmt.Sprintf("proxy.cache.ttlRules[%d].ttl", i), int64(rule.TTL))
+	}
+
+	v.oneOf("proxy.cacheHeaders.mode", c.Proxy.CacheHeaders.Mode, "", CacheHeadersPassthrough, CacheHeadersNoCache)
+	for i, rule := range c.Proxy.CacheHeaders.Rules {
+		field := fmt.Sprintf("proxy.cacheHeaders.rules[%d]", i)
+		if rule.Host == "" && rule.Path == "" {
+			v.add(field, "host和path至少设置一项")
+		}
+		if rule.Host != "" {
+			v.hostPattern(field+".host", rule.Host)
+		}
+		v.pathPattern(field+".path", rule.Path)
+		if rule.CacheControl == "" {
+			v.add(field+".cacheControl", "不能为空")
+		} else if strings.ContainsAny(rule.CacheControl, "\r\n") {
+			v.add(field+".cacheControl", "值不能包含换行")
+		}
+	}
+
+	if seg := c.Proxy.Segmented; seg.Enabled {
+		v.positive("proxy.segmented.segmentSize", seg.SegmentSize)
+		if seg.SegmentSize > maxSegmentSize {
+			v.add("proxy.segmented.segmentSize", "不能超过%d，当前为%d", maxSegmentSize, seg.SegmentSize)
+		}
+		if seg.Concurrency < 2 {
+			v.add("proxy.segmented.concurrency", "启用分段下载时至少为2，当前为%d", seg.Concurrency)
+		}
+	}
+	v.nonNegative("proxy.resume.maxRetries", int64(c.Proxy.Resume.MaxRetries))
+	if c.Proxy.Resume.MaxRetries > maxResumeRetries {
+		v.add("proxy.resume.maxRetries", "不能超过%d，当前为%d", maxResumeRetries, c.Proxy.Resume.MaxRetries)
+	}
+	v.nonNegative("proxy.resume.backoff", int64(c.Proxy.Resume.Backoff))
+	if c.Proxy.Resume.Backoff > maxResumeBackoff {
+		v.add("proxy.resume.backoff", "不能超过%d，当前为%d", maxResumeBackoff, c.Proxy.Resume.Backoff)
+	}
+
+	// 安全
+	if rate := c.Security.RateLimiting; rate.Enabled {
+		// 与proxy.NewLimiter支持的算法保持一致
+		v.oneOf("security.rateLimiting.algorithm", rate.Algorithm, "", "slidingWindow", "tokenBucket", "gcra")
+		v.positive("security.rateLimiting.requestsPerMinute", int64(rate.RequestsPerMinute))
+		v.nonNegative("security.rateLimiting.burst", int64(rate.Burst))
+		for i, p := range rate.ExemptPaths {
+			if !strings.HasPrefix(p, "/") {
+				v.add(fmt.Sprintf("security.rateLimiting.exemptPaths[%d]", i), "必须以/开头，当前为%q", p)
+			}
+		}
+	}
+
+	conc := c.Security.Concurrency
+	v.nonNegative("security.concurrency.perClient", int64(conc.PerClient))
+	v.nonNegative("security.concurrency.perHost", int64(conc.PerHost))
+	v.nonNegative("security.concurrency.global", int64(conc.Global))
+	v.atMost("security.concurrency.perClient", int64(conc.PerClient), "security.concurrency.global", int64(conc.Global))
+	v.atMost("security.concurrency.perHost", int64(conc.PerHost), "security.concurrency.global", int64(conc.Global))
+	v.oneOf("security.concurrency.mode", conc.Mode, "", "reject", "queue")
+	v.nonNegative("security.concurrency.queueTimeout", int64(conc.QueueTimeout))
+
+	bw := c.Security.Bandwidth
+	v.nonNegative("security.bandwidth.perClient", bw.PerClient)
+	v.nonNegative("security.bandwidth.global", bw.Global)
+	v.nonNegative("security.bandwidth.dailyQuota", bw.DailyQuota)
+	v.atMost("security.bandwidth.perClient", bw.PerClient, "security.bandwidth.global", bw.Global)
+
+	v.cidrs("security.network.denyCIDRs", c.Security.Network.DenyCIDRs)
+	v.cidrs("security.network.allowCIDRs", c.Security.Network.AllowCIDRs)
+	v.hostRules("security.hosts.allow", c.Security.Hosts.Allow)
+	v.hostRules("security.hosts.deny", c.Security.Hosts.Deny)
+	v.nonNegative("security.redirects.maxHops", int64(c.Security.Redirects.MaxHops))
+
+	// 头部策略
+	v.headerActions("headers.request", c.Headers.Request)
+	v.headerActions("headers.response", c.Headers.Response)
+	for i, rule := range c.Headers.Rules {
+		field := fmt.Sprintf("headers.rules[%d]", i)
+		v.hostPattern(field+".pattern", rule.Pattern)
+		v.headerActions(field+".request", rule.Request)
+		v.headerActions(field+".response", rule.Response)
+	}
+
+	// 管理接口
+	if c.Admin.Enabled && c.Admin.Token == "" {
+		v.add("admin.token", "启用管理接口时必须设置访问令牌")
+	}
+
+	// 链路追踪
+	if tr := c.Tracing; tr.Enabled {
+		v.oneOf("tracing.exporter", tr.Exporter, tracing.ExporterOTLP, tracing.ExporterStdout)
+		if tr.Exporter == tracing.ExporterOTLP {
+			if u, err := url.Parse(tr.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.add("tracing.endpoint", "必须是http或https地址，当前为%q", tr.Endpoint)
+			}
+		}
+		if tr.SampleRatio < 0 || tr.SampleRatio > 1 {
+			v.add("tracing.sampleRatio", "必须在0-1之间，当前为%g", tr.SampleRatio)
+		}
+		v.positive("tracing.timeout", int64(tr.Timeout))
+	}
+
+	// 日志
+	if _, err := utils.ParseLogLevel(c.Logging.Level); err != nil {
+		v.add("logging.level", "无效的取值%q，可选值为debug, info, warn, error", c.Logging.Level)
+	}
+	v.oneOf("logging.format", c.Logging.Format, "", "text", "json")
+
+	// 热加载
+	if c.Reload.Watch {
+		v.positive("reload.interval", int64(c.Reload.Interval))
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	// 来自配置文件的问题按行号排列在前
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return lineOf(v.problems[i].Location) < lineOf(v.problems[j].Location)
+	})
+	return &ValidationError{Problems: v.problems}
+}
+
+// lineOf 返回配置文件中的行号，不是来自配置文件时返回最大值
+func lineOf(location string) int {
+	if i := strings.LastIndexByte(location, ':'); i >= 0 {
+		if line, err := strconv.Atoi(location[i+1:]); err == nil {
+			return line
+		}
+	}
+	return math.MaxInt
+}
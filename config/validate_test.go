@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+// problemFields 返回校验发现问题的配置项
+func problemFields(t *testing.T, cfg *Config) []string {
+	t.Helper()
+	err := cfg.Validate()
+	if err == nil {
+		return nil
+	}
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Validate返回了非ValidationError的错误: %v", err)
+	}
+	fields := make([]string, len(invalid.Problems))
+	for i, p := range invalid.Problems {
+		fields[i] = p.Field
+	}
+	return fields
+}
+
+func TestValidateDefaultConfig(t *testing.T) {
+	if fields := problemFields(t, DefaultConfig()); len(fields) > 0 {
+		t.Fatalf("默认配置应当有效，发现问题: %v", fields)
+	}
+}
+
+func TestValidateSegmentSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		invalid bool
+	}{
+		{"默认大小", 8 * 1024 * 1024, false},
+		{"上限", maxSegmentSize, false},
+		{"超过上限", maxSegmentSize + 1, true},
+		{"数GB", 4 * 1024 * 1024 * 1024, true},
+		{"零", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Proxy.Segmented.Enabled = true
+			cfg.Proxy.Segmented.SegmentSize = tt.size
+
+			fields := problemFields(t, cfg)
+			got := len(fields) == 1 && fields[0] == "proxy.segmented.segmentSize"
+			if tt.invalid && !got {
+				t.Errorf("segmentSize=%d 应报告proxy.segmented.segmentSize无效，实际问题: %v", tt.size, fields)
+			}
+			if !tt.invalid && len(fields) > 0 {
+				t.Errorf("segmentSize=%d 应当有效，实际问题: %v", tt.size, fields)
+			}
+		})
+	}
+}
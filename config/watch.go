@@ -0,0 +1,33 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch 定期检查配置文件的修改时间和大小，发生变化时调用onChange
+// 编辑器保存时可能先删除再创建文件，文件暂时不存在时不触发
+func Watch(ctx context.Context, filename string, interval time.Duration, onChange func()) {
+	last, _ := os.Stat(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+			last = info
+			onChange()
+		}
+	}
+}
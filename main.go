@@ -2,112 +2,262 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/yourusername/proxy-service/config"
 	"github.com/yourusername/proxy-service/middleware"
-	"github.com/yourusername/proxy-service/proxy"
-	"github.com/yourusername/proxy-service/web"
+	"github.com/yourusername/proxy-service/tracing"
+	"github.com/yourusername/proxy-service/utils"
 )
 
 var (
-	configFile = flag.String("config", "config.yaml", "配置文件路径")
-)
-
-// logWriter 是一个自定义的日志写入器，用于添加时间戳
-type logWriter struct {
-	w io.Writer
-}
+	configFile     = flag.String("config", "config.yaml", "配置文件路径，也可以使用环境变量"+config.EnvConfigFile)
+	noWriteDefault = flag.Bool("no-write-default", false, "配置文件不存在时直接使用默认配置，不创建配置文件，也可以使用环境变量"+config.EnvNoWriteDefault)
 
-// Write 实现 io.Writer 接口
-func (lw *logWriter) Write(p []byte) (n int, err error) {
-	// 添加时间戳
-	timeStr := time.Now().Format("2006-01-02 15:04:05")
-	logLine := fmt.Sprintf("[%s] %s", timeStr, p)
-	return lw.w.Write([]byte(logLine))
-}
+	// 环境变量和命令行参数对配置项的覆盖
+	overrides = &config.Overrides{}
+)
 
 func main() {
-	flag.Parse()
+	overrides.RegisterFlags(flag.CommandLine)
 
-	// 配置日志格式，包含时间戳
-	log.SetFlags(log.Ldate | log.Ltime)
-
-	// 自定义日志格式，使用更友好的时间格式
-	log.SetFlags(0)   // 先清除所有标志
-	log.SetPrefix("") // 清除前缀
+	// dl-proxy config check 只检查配置，不启动服务
+	if len(os.Args) >= 3 && os.Args[1] == "config" && os.Args[2] == "check" {
+		os.Exit(checkConfig(os.Args[3:]))
+	}
 
-	// 设置自定义的日志输出
-	originalLogger := log.Default()
-	log.SetOutput(&logWriter{originalLogger.Writer()})
+	flag.Parse()
+	if err := applyEnvFlags(); err != nil {
+		fatal("读取环境变量失败", err)
+	}
+	unknownEnv := overrides.LoadEnv(os.Environ())
 
 	// 加载配置文件
-	cfg, err := config.LoadConfig(*configFile)
+	cfg, err := loadConfig(false)
 	if err != nil {
-		log.Fatalf("加载配置文件失败: %v", err)
+		logConfigError("加载配置文件失败", err)
+		os.Exit(1)
 	}
 
-	// 初始化请求限制器
-	rateLimiter := proxy.NewRateLimiter(cfg.Security.RateLimiting.RequestsPerMinute)
+	// 按配置的级别和格式输出结构化日志，标准库log的输出也会经过该记录器
+	logger, err := utils.NewLogger(os.Stderr, cfg.Logging.Level, cfg.Logging.Format)
+	if err != nil {
+		fatal("初始化日志失败", err)
+	}
+	slog.SetDefault(logger)
+	for _, name := range unknownEnv {
+		slog.Warn("忽略不对应任何配置项的环境变量", "name", name)
+	}
 
-	// 构建HTTP处理链
-	handler := proxy.NewProxyHandler(cfg)
+	// 链路追踪，span按批次导出到OTLP后端
+	var tracer *tracing.Tracer
+	if traceCfg := cfg.Tracing; traceCfg.Enabled {
+		exporter, err := tracing.NewExporter(traceCfg.Exporter, traceCfg.Endpoint, traceCfg.Headers,
+			time.Duration(traceCfg.Timeout)*time.Second, traceCfg.ServiceName, os.Stdout)
+		if err != nil {
+			fatal("初始化链路追踪失败", err)
+		}
+		tracer = tracing.Setup(exporter, traceCfg.SampleRatio)
+	}
 
-	// 注册静态资源和主页
-	mux := http.NewServeMux()
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
-	mux.Handle("/", rootHandler(web.HomeHandler(), handler))
-	mux.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}))
-	// 所有其他请求都交给代理处理器
-	mux.Handle("/*", handler)
+	// 客户端IP解析器，只信任来自可信代理的转发头
+	ipResolver, err := utils.NewClientIPResolver(cfg.Server.TrustedProxies)
+	if err != nil {
+		fatal("解析可信代理列表失败", err)
+	}
+
+	// 构建HTTP处理链，重新加载配置时整体替换
+	svc, err := newService(cfg, nil)
+	if err != nil {
+		fatal("初始化服务失败", err)
+	}
+	live := &liveHandler{}
+	live.store(svc.handler)
 
 	// 应用中间件
-	wrappedHandler := middleware.Recovery(
-		middleware.Logging(
-			proxy.LimitRate(rateLimiter, mux),
+	wrappedHandler := middleware.RealIP(ipResolver,
+		middleware.RequestID(ipResolver,
+			middleware.Tracing(ipResolver,
+				middleware.Recovery(
+					middleware.Logging(live),
+				),
+			),
 		),
 	)
 
-	// 创建服务器
+	// 创建服务器，写超时由处理链按当前的transferTimeout为每个请求设置，见withWriteDeadline
 	server := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler:      wrappedHandler,
-		ReadTimeout:  time.Duration(cfg.Proxy.ConnectTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Proxy.TransferTimeout) * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler:           wrappedHandler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	// 监听端口，位于负载均衡器之后时解析PROXY协议头
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		fatal("服务器启动失败", err)
+	}
+	if cfg.Server.ProxyProtocol {
+		listener = utils.NewProxyProtoListener(listener, ipResolver)
 	}
 
 	// 启动服务器
 	go func() {
-		log.Printf("代理服务器正在监听 %s:%d\n", cfg.Server.Host, cfg.Server.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("服务器启动失败: %v\n", err)
+		slog.Info("代理服务器正在监听", "addr", server.Addr)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fatal("服务器启动失败", err)
 		}
 	}()
 
+	// 收到SIGHUP或配置文件变化时重新加载配置
+	rl := &reloader{
+		load:     func() (*config.Config, error) { return loadConfig(true) },
+		resolver: ipResolver,
+		live:     live,
+		current:  svc,
+	}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if cfg.Reload.Watch && cfg.Reload.Interval > 0 {
+		go config.Watch(watchCtx, *configFile, time.Duration(cfg.Reload.Interval)*time.Second, func() {
+			rl.reload("文件变化")
+		})
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	// 等待信号来优雅关闭服务器
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("正在关闭服务器...")
+	for running := true; running; {
+		select {
+		case <-hup:
+			rl.reload("SIGHUP")
+		case <-quit:
+			running = false
+		}
+	}
+	slog.Info("正在关闭服务器...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("服务器关闭失败: %v\n", err)
+		fatal("服务器关闭失败", err)
+	}
+	// 导出剩余的追踪数据
+	if tracer != nil {
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Warn("导出剩余追踪数据失败", "error", err)
+		}
 	}
-	log.Println("服务器已优雅关闭")
+	slog.Info("服务器已优雅关闭")
+}
+
+// applyEnvFlags 命令行中没有指定-config和-no-write-default时，使用DLPROXY_CONFIG和DLPROXY_NO_WRITE_DEFAULT，
+// 使容器可以完全通过环境变量配置
+func applyEnvFlags() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, ef := range []struct{ flag, env string }{
+		{"config", config.EnvConfigFile},
+		{"no-write-default", config.EnvNoWriteDefault},
+	} {
+		value, ok := os.LookupEnv(ef.env)
+		if !ok || set[ef.flag] {
+			continue
+		}
+		if err := flag.Set(ef.flag, value); err != nil {
+			return fmt.Errorf("环境变量 %s: %v", ef.env, err)
+		}
+	}
+	return nil
+}
+
+// loadConfig 加载配置文件，再依次应用环境变量和命令行参数并校验
+// requireFile为true时配置文件必须存在，启用-no-write-default时除外，
+// 用于重新加载和检查配置，避免文件被误删后退回默认配置
+func loadConfig(requireFile bool) (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+	if requireFile && !*noWriteDefault {
+		cfg, err = config.ReadConfig(*configFile)
+	} else {
+		cfg, err = config.LoadConfig(*configFile, !*noWriteDefault)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := overrides.Apply(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// logConfigError 记录加载配置失败的原因，配置无效时每个问题单独记录一条
+func logConfigError(msg string, err error, args ...any) {
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		slog.Error(msg, append(args, "error", err)...)
+		return
+	}
+	for _, p := range invalid.Problems {
+		slog.Error(msg, append(args, "location", p.Location, "field", p.Field, "error", p.Message)...)
+	}
+}
+
+// checkConfig 实现config check子命令，检查配置文件以及环境变量和命令行参数的覆盖，
+// 输出发现的所有问题，配置有效时返回0，可用于CI
+func checkConfig(args []string) int {
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "未知的参数: %s\n", strings.Join(flag.Args(), " "))
+		return 2
+	}
+	if err := applyEnvFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "读取环境变量失败: %v\n", err)
+		return 1
+	}
+	for _, name := range overrides.LoadEnv(os.Environ()) {
+		fmt.Fprintf(os.Stderr, "警告: 忽略不对应任何配置项的环境变量 %s\n", name)
+	}
+
+	if _, err := loadConfig(true); err != nil {
+		var invalid *config.ValidationError
+		if !errors.As(err, &invalid) {
+			fmt.Fprintf(os.Stderr, "加载配置文件失败: %v\n", err)
+			return 1
+		}
+		for _, p := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		fmt.Fprintf(os.Stderr, "配置无效，共%d个问题\n", len(invalid.Problems))
+		return 1
+	}
+
+	fmt.Printf("配置有效: %s\n", *configFile)
+	return 0
+}
+
+// fatal 记录错误并退出程序
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 // rootHandler 处理根路径请求，区分主页和代理请求
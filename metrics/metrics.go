@@ -0,0 +1,343 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// metric 可以按Prometheus文本格式输出的指标
+type metric interface {
+	// header 返回指标名称、说明和类型
+	header() (name, help, typ string)
+	// writeSamples 输出该指标的所有样本
+	writeSamples(w *bufio.Writer)
+}
+
+// registry 保存所有已注册的指标，按注册顺序输出
+var registry struct {
+	metrics []metric
+	names   map[string]bool
+	mu      sync.Mutex
+}
+
+// register 注册指标，名称重复时直接panic，便于在启动阶段发现错误
+func register(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	name, _, _ := m.header()
+	if registry.names == nil {
+		registry.names = make(map[string]bool)
+	}
+	if registry.names[name] {
+		panic(fmt.Sprintf("指标重复注册: %s", name))
+	}
+	registry.names[name] = true
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc 计数加一
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add 增加计数，负数会被忽略
+func (c *Counter) Add(n int64) {
+	if n > 0 {
+		c.value.Add(n)
+	}
+}
+
+// counterMetric 不带标签的计数器
+type counterMetric struct {
+	name, help string
+	counter    Counter
+}
+
+// NewCounter 创建并注册不带标签的计数器
+func NewCounter(name, help string) *Counter {
+	m := &counterMetric{name: name, help: help}
+	register(m)
+	return &m.counter
+}
+
+func (m *counterMetric) header() (string, string, string) {
+	return m.name, m.help, "counter"
+}
+
+func (m *counterMetric) writeSamples(w *bufio.Writer) {
+	writeSample(w, m.name, "", float64(m.counter.value.Load()))
+}
+
+// CounterVec 按标签区分的一组计数器
+type CounterVec struct {
+	name, help string
+	labels     labelSet
+}
+
+// NewCounterVec 创建并注册带标签的计数器
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := &CounterVec{name: name, help: help, labels: newLabelSet(labelNames, func() any { return &Counter{} })}
+	register(v)
+	return v
+}
+
+// WithLabelValues 返回指定标签值的计数器，不存在时创建
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.labels.get(values).(*Counter)
+}
+
+func (v *CounterVec) header() (string, string, string) {
+	return v.name, v.help, "counter"
+}
+
+func (v *CounterVec) writeSamples(w *bufio.Writer) {
+	v.labels.each(func(labels string, child any) {
+		writeSample(w, v.name, labels, float64(child.(*Counter).value.Load()))
+	})
+}
+
+// funcMetric 在输出时调用函数取值的指标，用于暴露其他模块已有的统计
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+// NewGaugeFunc 注册取值函数形式的仪表盘指标
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// NewCounterFunc 注册取值函数形式的计数器，函数返回值应单调递增
+func NewCounterFunc(name, help string, fn func() float64) {
+	register(&funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+func (m *funcMetric) header() (string, string, string) {
+	return m.name, m.help, m.typ
+}
+
+func (m *funcMetric) writeSamples(w *bufio.Writer) {
+	writeSample(w, m.name, "", m.fn())
+}
+
+// Histogram 统计观测值在各个区间的分布
+type Histogram struct {
+	buckets []float64 // 各区间的上限，升序排列
+	counts  []uint64
+	sum     float64
+	count   uint64
+	mu      sync.Mutex
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec 按标签区分的一组直方图
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     labelSet
+}
+
+// DefBuckets 默认的直方图区间（秒），适用于网络请求耗时
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogramVec 创建并注册带标签的直方图
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	v := &HistogramVec{name: name, help: help, buckets: buckets}
+	v.labels = newLabelSet(labelNames, func() any {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	register(v)
+	return v
+}
+
+// WithLabelValues 返回指定标签值的直方图，不存在时创建
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.labels.get(values).(*Histogram)
+}
+
+func (v *HistogramVec) header() (string, string, string) {
+	return v.name, v.help, "histogram"
+}
+
+func (v *HistogramVec) writeSamples(w *bufio.Writer) {
+	v.labels.each(func(labels string, child any) {
+		h := child.(*Histogram)
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		sum, count := h.sum, h.count
+		h.mu.Unlock()
+
+		// 区间计数需要累加输出
+		prefix := labels
+		if prefix != "" {
+			prefix += ","
+		}
+		var cumulative uint64
+		for i, upper := range v.buckets {
+			cumulative += counts[i]
+			writeSample(w, v.name+"_bucket", prefix+`le="`+formatFloat(upper)+`"`, float64(cumulative))
+		}
+		writeSample(w, v.name+"_bucket", prefix+`le="+Inf"`, float64(count))
+		writeSample(w, v.name+"_sum", labels, sum)
+		writeSample(w, v.name+"_count", labels, float64(count))
+	})
+}
+
+// labelSet 按标签值保存子指标
+type labelSet struct {
+	names    []string
+	newChild func() any
+	children map[string]*labeledChild
+	mu       sync.RWMutex
+}
+
+// labeledChild 子指标及其格式化后的标签
+type labeledChild struct {
+	labels string
+	child  any
+}
+
+func newLabelSet(names []string, newChild func() any) labelSet {
+	return labelSet{
+		names:    names,
+		newChild: newChild,
+		children: make(map[string]*labeledChild),
+	}
+}
+
+// get 返回标签值对应的子指标，标签值数量与标签名不一致时panic
+func (ls *labelSet) get(values []string) any {
+	if len(values) != len(ls.names) {
+		panic(fmt.Sprintf("标签数量不匹配: 需要%d个, 实际%d个", len(ls.names), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	ls.mu.RLock()
+	c, ok := ls.children[key]
+	ls.mu.RUnlock()
+	if ok {
+		return c.child
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if c, ok := ls.children[key]; ok {
+		return c.child
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = ls.names[i] + `="` + escapeLabelValue(value) + `"`
+	}
+	c = &labeledChild{labels: strings.Join(pairs, ","), child: ls.newChild()}
+	ls.children[key] = c
+	return c.child
+}
+
+// each 按标签排序遍历所有子指标，保证输出顺序稳定
+func (ls *labelSet) each(fn func(labels string, child any)) {
+	ls.mu.RLock()
+	children := make([]*labeledChild, 0, len(ls.children))
+	for _, c := range ls.children {
+		children = append(children, c)
+	}
+	ls.mu.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].labels < children[j].labels
+	})
+	for _, c := range children {
+		fn(c.labels, c.child)
+	}
+}
+
+// escapeLabelValue 转义标签值中的反斜杠、双引号和换行
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeSample 输出一行样本
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatFloat 按Prometheus的格式输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write 按Prometheus文本格式输出所有已注册的指标
+func Write(out io.Writer) error {
+	registry.mu.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	registry.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, m := range metrics {
+		name, help, typ := m.header()
+		fmt.Fprintf(w, "# HELP %s %s\n", name, strings.ReplaceAll(help, "\n", " "))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+		m.writeSamples(w)
+	}
+	return w.Flush()
+}
+
+// Handler 返回输出指标的HTTP处理器
+// token不为空时要求请求携带 Authorization: Bearer <token> 头或token查询参数
+func Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !utils.ValidToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			utils.HTTPError(w, r, "未授权", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		Write(w)
+	})
+}
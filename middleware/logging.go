@@ -1,12 +1,27 @@
 package middleware
 
 import (
-	"fmt"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/yourusername/proxy-service/metrics"
+	"github.com/yourusername/proxy-service/utils"
+)
+
+var (
+	// 按状态码统计的请求数
+	requestsTotal = metrics.NewCounterVec(
+		"dl_proxy_http_requests_total",
+		"按响应状态码统计的请求数",
+		"code")
+
+	// 发送给客户端的响应体字节数
+	responseBytes = metrics.NewCounter(
+		"dl_proxy_response_bytes_total",
+		"发送给客户端的响应体字节数")
 )
 
 // Logging 中间件记录每个请求的访问日志
@@ -15,7 +30,7 @@ func Logging(next http.Handler) http.Handler {
 		start := time.Now()
 
 		// 获取客户端IP
-		clientIP := getClientIP(r)
+		clientIP := utils.ClientIP(r)
 
 		// 创建响应记录器
 		recorder := &responseRecorder{
@@ -27,55 +42,26 @@ func Logging(next http.Handler) http.Handler {
 		// 调用下一个处理器
 		next.ServeHTTP(recorder, r)
 
+		requestsTotal.WithLabelValues(strconv.Itoa(recorder.status)).Inc()
+		responseBytes.Add(int64(recorder.length))
+
 		// 跳过对下载请求的重复日志记录，因为已经在handler中记录了详细信息
 		if isDownloadRequest(r.URL.Path) {
 			return
 		}
 
 		// 记录请求完成情况
-		log.Printf(
-			"客户端: %s | 路径: %s | 方法: %s | 状态: %d | 大小: %s | 耗时: %.2f秒",
-			clientIP,
-			r.URL.Path,
-			r.Method,
-			recorder.status,
-			formatSize(recorder.length),
-			time.Since(start).Seconds(),
+		slog.InfoContext(r.Context(), "请求完成",
+			"client_ip", clientIP,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.length,
+			"duration", time.Since(start),
 		)
 	})
 }
 
-// getClientIP 获取客户端真实IP地址
-func getClientIP(r *http.Request) string {
-	// 首先尝试从X-Forwarded-For头获取
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		// 取第一个IP（最原始的客户端IP）
-		if len(ips) > 0 {
-			clientIP := strings.TrimSpace(ips[0])
-			if clientIP != "" {
-				return clientIP
-			}
-		}
-	}
-
-	// 尝试从X-Real-IP头获取
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return strings.TrimSpace(xrip)
-	}
-
-	// 从RemoteAddr获取
-	if r.RemoteAddr != "" {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err == nil {
-			return ip
-		}
-		return r.RemoteAddr
-	}
-
-	return "未知IP"
-}
-
 // isDownloadRequest 判断是否为下载请求
 func isDownloadRequest(path string) bool {
 	return strings.HasPrefix(path, "/http:/") ||
@@ -102,30 +88,14 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// formatSize 根据大小自动选择合适的单位
-func formatSize(size int) string {
-	if size < 0 {
-		return "未知大小"
+// Flush 实现http.Flusher接口，供流式响应使用
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
+}
 
-	const (
-		B  = 1
-		KB = 1024 * B
-		MB = 1024 * KB
-		GB = 1024 * MB
-		TB = 1024 * GB
-	)
-
-	switch {
-	case size >= TB:
-		return fmt.Sprintf("%.2f TB", float64(size)/TB)
-	case size >= GB:
-		return fmt.Sprintf("%.2f GB", float64(size)/GB)
-	case size >= MB:
-		return fmt.Sprintf("%.2f MB", float64(size)/MB)
-	case size >= KB:
-		return fmt.Sprintf("%.2f KB", float64(size)/KB)
-	default:
-		return fmt.Sprintf("%d B", size)
-	}
+// Unwrap 返回原始的ResponseWriter，供http.ResponseController使用
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
 }
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// RealIP 中间件根据可信代理配置解析客户端真实IP并保存到请求上下文中
+// 之后的日志、频率限制和下载跟踪都通过utils.ClientIP读取同一个结果
+func RealIP(resolver *utils.ClientIPResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, utils.WithClientIP(r, resolver.Resolve(r)))
+	})
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// requestIDHeader 请求ID使用的头
+const requestIDHeader = "X-Request-ID"
+
+// RequestID 中间件为每个请求分配唯一ID，保存到请求上下文并在响应头中返回
+// 只有来自可信代理的请求才会沿用其携带的X-Request-ID，其余请求一律重新生成
+func RequestID(resolver *utils.ClientIPResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) || !resolver.TrustedPeer(r) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID 检查请求ID的长度和字符，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/yourusername/proxy-service/tracing"
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// Tracing 中间件为每个请求创建服务端span，作为代理处理各阶段span的父span
+// 只有来自可信代理的请求才会沿用其traceparent头，加入调用方的追踪链路
+func Tracing(resolver *utils.ClientIPResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if resolver.TrustedPeer(r) {
+			if parent, ok := tracing.Extract(r.Header); ok {
+				ctx = tracing.ContextWithRemoteParent(ctx, parent)
+			}
+		}
+
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method, tracing.KindServer)
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		span.SetAttributes(
+			"http.request.method", r.Method,
+			"url.path", r.URL.Path,
+			"client.address", utils.ClientIP(r),
+			"request_id", utils.RequestID(ctx))
+
+		recorder := &responseRecorder{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(
+			"http.response.status_code", recorder.status,
+			"http.response.body.size", recorder.length)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatusError(http.StatusText(recorder.status))
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// AdminPrefix 管理接口的路径前缀
+const AdminPrefix = "/admin/api/"
+
+// AdminHandler 返回管理接口的HTTP处理器，所有请求都需要携带访问令牌
+//
+//	GET    /admin/api/downloads             列出进行中和最近结束的下载
+//	GET    /admin/api/downloads/{id}        查看单个下载
+//	DELETE /admin/api/downloads/{id}        取消下载
+//	POST   /admin/api/downloads/{id}/cancel 取消下载
+func AdminHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !utils.ValidToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAdminError(w, r, http.StatusUnauthorized, "未授权")
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/")
+		parts := strings.Split(path, "/")
+		if parts[0] != "downloads" {
+			writeAdminError(w, r, http.StatusNotFound, "接口不存在")
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			listDownloads(w)
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			getDownload(w, r, parts[1])
+		case len(parts) == 2 && r.Method == http.MethodDelete,
+			len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+			cancelDownload(w, r, parts[1])
+		case len(parts) <= 3:
+			writeAdminError(w, r, http.StatusMethodNotAllowed, "不支持的请求方法")
+		default:
+			writeAdminError(w, r, http.StatusNotFound, "接口不存在")
+		}
+	})
+}
+
+// listDownloads 按状态分组输出所有下载记录
+func listDownloads(w http.ResponseWriter) {
+	result := struct {
+		Active []DownloadStatus `json:"active"`
+		Recent []DownloadStatus `json:"recent"`
+	}{
+		Active: []DownloadStatus{},
+		Recent: []DownloadStatus{},
+	}
+	for _, status := range downloadTracker.Downloads() {
+		if status.State == downloadActive {
+			result.Active = append(result.Active, status)
+		} else {
+			result.Recent = append(result.Recent, status)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// getDownload 输出单个下载记录
+func getDownload(w http.ResponseWriter, r *http.Request, id string) {
+	status, ok := downloadTracker.Download(id)
+	if !ok {
+		writeAdminError(w, r, http.StatusNotFound, "下载不存在")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// cancelDownload 取消下载的所有进行中的连接
+func cancelDownload(w http.ResponseWriter, r *http.Request, id string) {
+	found, err := downloadTracker.Cancel(id)
+	switch {
+	case !found:
+		writeAdminError(w, r, http.StatusNotFound, "下载不存在")
+		return
+	case err != nil:
+		writeAdminError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+
+	slog.InfoContext(r.Context(), "管理接口取消下载",
+		"client_ip", utils.ClientIP(r),
+		"download_id", id)
+	writeJSON(w, http.StatusAccepted, map[string]any{"id": id, "cancelled": true})
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeAdminError 输出JSON格式的错误，附带请求ID
+func writeAdminError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeJSON(w, status, map[string]string{
+		"error":      msg,
+		"request_id": utils.RequestID(r.Context()),
+	})
+}
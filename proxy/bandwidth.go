@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errQuotaExceeded 传输过程中客户端今日的流量配额用完
+var errQuotaExceeded = errors.New("今日流量配额已用完")
+
+// bandwidthLimiter 限制响应体的发送速率并统计每个客户端的每日流量
+// 同一客户端的所有连接共享一个速率限制，所有客户端再共享全局速率限制
+type bandwidthLimiter struct {
+	perClient  float64 // 每个客户端每秒字节数，0表示不限制
+	dailyQuota int64   // 每个客户端每日字节数，0表示不限制
+	global     *byteBucket
+
+	clients   map[string]*clientBandwidth
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// clientBandwidth 单个客户端的速率和流量状态
+type clientBandwidth struct {
+	bucket *byteBucket
+	day    string // 流量统计所属的日期
+	used   int64
+}
+
+// byteBucket 以字节为单位的令牌桶
+// 允许令牌为负数，发送方按欠下的令牌数等待，从而支持任意大小的写入
+type byteBucket struct {
+	rate   float64 // 每秒补充的字节数
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// newBandwidthLimiter 创建带宽限制器，所有限制都未开启时返回nil
+func newBandwidthLimiter(perClient, global, dailyQuota int64) *bandwidthLimiter {
+	if perClient <= 0 && global <= 0 && dailyQuota <= 0 {
+		return nil
+	}
+
+	bl := &bandwidthLimiter{
+		dailyQuota: dailyQuota,
+		clients:    make(map[string]*clientBandwidth),
+		lastSweep:  time.Now(),
+	}
+	if perClient > 0 {
+		bl.perClient = float64(perClient)
+	}
+	if global > 0 {
+		bl.global = newByteBucket(float64(global))
+	}
+	return bl
+}
+
+// newByteBucket 创建令牌桶，最多允许1秒的突发流量
+func newByteBucket(rate float64) *byteBucket {
+	return &byteBucket{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// reserve 预定n个字节，返回发送前需要等待的时间
+func (b *byteBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// inheritUsage 重新加载配置时沿用旧限制器中各客户端的当日流量
+// 速率限制的令牌按新的配置重新计算
+func (bl *bandwidthLimiter) inheritUsage(old *bandwidthLimiter) {
+	if bl == nil || old == nil {
+		return
+	}
+
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	for ip, prev := range old.clients {
+		cb := bl.client(ip, prev.day)
+		cb.used = prev.used
+	}
+}
+
+// client 获取客户端状态，跨天时重置流量统计，调用方需持有锁
+func (bl *bandwidthLimiter) client(ip string, today string) *clientBandwidth {
+	cb, exists := bl.clients[ip]
+	if !exists {
+		cb = &clientBandwidth{day: today}
+		if bl.perClient > 0 {
+			cb.bucket = newByteBucket(bl.perClient)
+		}
+		bl.clients[ip] = cb
+	}
+	if cb.day != today {
+		cb.day = today
+		cb.used = 0
+	}
+	return cb
+}
+
+// quotaExceeded 判断客户端今日的流量是否已用完，返回已使用的字节数
+func (bl *bandwidthLimiter) quotaExceeded(ip string) (bool, int64) {
+	if bl == nil || bl.dailyQuota <= 0 {
+		return false, 0
+	}
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	cb := bl.client(ip, time.Now().Format("2006-01-02"))
+	return cb.used >= bl.dailyQuota, cb.used
+}
+
+// throttle 在发送n个字节前调用，统计流量并按速率限制等待，返回允许发送的字节数
+// 今日剩余的流量配额不足n个字节时只允许发送剩余的部分，并返回errQuotaExceeded
+func (bl *bandwidthLimiter) throttle(ctx context.Context, ip string, n int) (int, error) {
+	if bl == nil || n <= 0 {
+		return n, nil
+	}
+
+	now := time.Now()
+	var quotaErr error
+	bl.mu.Lock()
+	bl.sweep(now)
+	cb := bl.client(ip, now.Format("2006-01-02"))
+	if bl.dailyQuota > 0 {
+		if remaining := bl.dailyQuota - cb.used; remaining < int64(n) {
+			n = int(max(remaining, 0))
+			quotaErr = errQuotaExceeded
+		}
+	}
+	cb.used += int64(n)
+	bucket := cb.bucket
+	bl.mu.Unlock()
+
+	var delay time.Duration
+	if bucket != nil && n > 0 {
+		delay = bucket.reserve(n)
+	}
+	if bl.global != nil && n > 0 {
+		if globalDelay := bl.global.reserve(n); globalDelay > delay {
+			delay = globalDelay
+		}
+	}
+	if delay <= 0 {
+		return n, quotaErr
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return n, quotaErr
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// sweep 定期删除不是今天的流量记录，调用方需持有锁
+func (bl *bandwidthLimiter) sweep(now time.Time) {
+	if now.Sub(bl.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	bl.lastSweep = now
+
+	today := now.Format("2006-01-02")
+	for ip, cb := range bl.clients {
+		if cb.day != today {
+			delete(bl.clients, ip)
+		}
+	}
+}
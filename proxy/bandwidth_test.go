@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestThrottleDailyQuota(t *testing.T) {
+	bl := newBandwidthLimiter(0, 0, 100)
+	ctx := context.Background()
+
+	if n, err := bl.throttle(ctx, "10.0.0.1", 60); n != 60 || err != nil {
+		t.Fatalf("配额内的写入返回 %d, %v，应为 60, nil", n, err)
+	}
+	// 剩余40字节，只允许发送剩余部分
+	if n, err := bl.throttle(ctx, "10.0.0.1", 60); n != 40 || !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("超出配额的写入返回 %d, %v，应为 40, errQuotaExceeded", n, err)
+	}
+	if n, err := bl.throttle(ctx, "10.0.0.1", 1); n != 0 || !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("配额用完后的写入返回 %d, %v，应为 0, errQuotaExceeded", n, err)
+	}
+	if exceeded, used := bl.quotaExceeded("10.0.0.1"); !exceeded || used != 100 {
+		t.Errorf("quotaExceeded返回 %v, %d，应为 true, 100", exceeded, used)
+	}
+	// 其他客户端不受影响
+	if n, err := bl.throttle(ctx, "10.0.0.2", 60); n != 60 || err != nil {
+		t.Errorf("其他客户端的写入返回 %d, %v，应为 60, nil", n, err)
+	}
+}
+
+func TestTrackedWriterStopsAtQuota(t *testing.T) {
+	bl := newBandwidthLimiter(0, 0, 100)
+	r := httptest.NewRequest(http.MethodGet, "/http:/example.com/quota.bin", nil)
+	rec := httptest.NewRecorder()
+	tw := newTrackedWriter(rec, r, "http://example.com/quota.bin", "quota.bin", "10.0.0.3", bl)
+
+	// 两个请求同时通过了入口处的配额检查，传输过程中共享剩余配额
+	if _, err := bl.throttle(context.Background(), "10.0.0.3", 70); err != nil {
+		t.Fatalf("另一个传输的写入失败: %v", err)
+	}
+
+	tw.WriteHeader(http.StatusOK)
+	n, err := tw.Write(make([]byte, 50))
+	tw.finish(err)
+	if n != 30 || !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("Write返回 %d, %v，应为 30, errQuotaExceeded", n, err)
+	}
+	if rec.Body.Len() != 30 {
+		t.Errorf("客户端收到%d字节，应为30字节", rec.Body.Len())
+	}
+	if !errors.Is(tw.err, errQuotaExceeded) {
+		t.Errorf("tw.err为%v，应为errQuotaExceeded", tw.err)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"io"
+	"math/bits"
+	"time"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+const (
+	adaptInterval     = 500 * time.Millisecond // 自适应模式重新计算缓冲区大小的间隔
+	readsPerTransfer  = 64                     // 按响应长度选择初始大小时，期望的读取次数
+	bufferedPerSecond = 100                    // 缓冲区约容纳1/100秒传输的数据
+)
+
+// bufferPolicy 决定每次传输使用的缓冲区大小
+// 固定模式始终使用bufferSize；自适应模式根据Content-Length选择初始大小，
+// 传输过程中再按实测吞吐量调整，小文件和慢速传输不必占用大缓冲区，高速传输减少读写次数
+type bufferPolicy struct {
+	size     int
+	adaptive bool
+	min, max int
+}
+
+// newBufferPolicy 根据配置创建缓冲区策略
+func newBufferPolicy(cfg *config.Config) bufferPolicy {
+	adaptive := cfg.Proxy.AdaptiveBuffer
+	return bufferPolicy{
+		size:     cfg.Proxy.BufferSize,
+		adaptive: adaptive.Enabled,
+		min:      adaptive.MinSize,
+		max:      adaptive.MaxSize,
+	}
+}
+
+// initialSize 根据响应长度选择初始缓冲区大小，长度未知时使用bufferSize
+func (bp bufferPolicy) initialSize(contentLength int64) int {
+	if !bp.adaptive {
+		return bp.size
+	}
+	if contentLength < 0 {
+		return bp.clamp(int64(bp.size))
+	}
+	return bp.clamp(contentLength / readsPerTransfer)
+}
+
+// sizeFor 按吞吐量(字节/秒)计算缓冲区大小
+func (bp bufferPolicy) sizeFor(bytesPerSecond float64) int {
+	return bp.clamp(int64(bytesPerSecond / bufferedPerSecond))
+}
+
+// clamp 将大小向上取整为2的幂并限制在[min, max]之间，减少缓冲区池中不同大小的数量
+func (bp bufferPolicy) clamp(size int64) int {
+	if size <= int64(bp.min) {
+		return bp.min
+	}
+	if size >= int64(bp.max) {
+		return bp.max
+	}
+	return min(1<<bits.Len64(uint64(size-1)), bp.max)
+}
+
+// copy 使用缓冲区池中的缓冲区将src复制到dst，返回复制的字节数
+func (bp bufferPolicy) copy(dst io.Writer, src io.Reader, contentLength int64) (int64, error) {
+	buffer := bufferPool.Get(bp.initialSize(contentLength))
+	defer func() { bufferPool.Put(buffer) }()
+
+	if !bp.adaptive {
+		return io.CopyBuffer(dst, src, buffer)
+	}
+
+	var written int64
+	windowStart, windowBytes := time.Now(), 0
+	for {
+		nr, readErr := src.Read(buffer)
+		if nr > 0 {
+			nw, err := dst.Write(buffer[:nr])
+			written += int64(nw)
+			if err != nil {
+				return written, err
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+
+		// 吞吐量包含写入客户端的耗时，客户端较慢或受带宽限制时缓冲区随之变小
+		windowBytes += nr
+		if elapsed := time.Since(windowStart); elapsed >= adaptInterval {
+			if size := bp.sizeFor(float64(windowBytes) / elapsed.Seconds()); size != len(buffer) {
+				bufferPool.Put(buffer)
+				buffer = bufferPool.Get(size)
+			}
+			windowStart, windowBytes = time.Now(), 0
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 比较固定大小和自适应缓冲区的复制吞吐量
+// 数据写入本机TCP连接，每次Write对应一次系统调用，与向客户端转发时的开销一致
+//
+//	go test ./proxy -run '^$' -bench BufferCopy
+func BenchmarkBufferCopy(b *testing.B) {
+	policies := []struct {
+		name   string
+		policy bufferPolicy
+	}{
+		{"fixed-4KB", bufferPolicy{size: 4 * 1024}},
+		{"fixed-32KB", bufferPolicy{size: 32 * 1024}},
+		{"adaptive", bufferPolicy{size: 32 * 1024, adaptive: true, min: 4 * 1024, max: 1024 * 1024}},
+	}
+	profiles := []struct {
+		name string
+		rate int64 // 上游速度(字节/秒)，0表示不限速
+	}{
+		{"unlimited", 0},
+		{"upstream-64MBps", 64 * 1024 * 1024},
+	}
+	lengths := []struct {
+		name          string
+		size          int64
+		contentLength int64 // -1表示上游没有返回Content-Length
+	}{
+		{"256KB", 256 * 1024, 256 * 1024},
+		{"4MB", 4 * 1024 * 1024, 4 * 1024 * 1024},
+		{"32MB", 32 * 1024 * 1024, 32 * 1024 * 1024},
+		{"64MB-unknown-length", 64 * 1024 * 1024, -1}, // 自适应模式在传输0.5秒后才按速度调整
+	}
+
+	for _, profile := range profiles {
+		for _, length := range lengths {
+			for _, p := range policies {
+				name := fmt.Sprintf("%s/%s/%s", profile.name, length.name, p.name)
+				b.Run(name, func(b *testing.B) {
+					dst := newLoopbackSink(b)
+					b.SetBytes(length.size)
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						src := &rateLimitedReader{remaining: length.size, rate: profile.rate}
+						n, err := p.policy.copy(dst, src, length.contentLength)
+						if err != nil || n != length.size {
+							b.Fatalf("复制了%d字节，应为%d: %v", n, length.size, err)
+						}
+					}
+					b.StopTimer()
+					b.ReportMetric(float64(dst.writes)/float64(b.N), "writes/op")
+					b.ReportMetric(float64(dst.maxWrite), "max-write-B")
+				})
+			}
+		}
+	}
+}
+
+// rateLimitedReader 按指定速度产生数据的上游响应体
+type rateLimitedReader struct {
+	remaining int64
+	rate      int64
+	read      int64
+	start     time.Time
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	n := int(min(int64(len(p)), r.remaining))
+	clear(p[:n])
+	r.remaining -= int64(n)
+	r.read += int64(n)
+
+	// 超前1ms以上时等待，避免频繁的短暂休眠
+	if r.rate > 0 {
+		due := r.start.Add(time.Duration(float64(r.read) / float64(r.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > time.Millisecond {
+			time.Sleep(wait)
+		}
+	}
+	return n, nil
+}
+
+// loopbackSink 写入本机TCP连接，另一端读取并丢弃，记录写入次数和单次写入的最大字节数
+type loopbackSink struct {
+	conn     net.Conn
+	writes   int64
+	maxWrite int
+}
+
+func newLoopbackSink(b *testing.B) *loopbackSink {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Skipf("无法监听本机地址: %v", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatalf("连接本机地址失败: %v", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return &loopbackSink{conn: conn}
+}
+
+func (s *loopbackSink) Write(p []byte) (int, error) {
+	s.writes++
+	s.maxWrite = max(s.maxWrite, len(p))
+	return s.conn.Write(p)
+}
@@ -0,0 +1,421 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 需要随缓存条目一起保存的响应头
+var cachedHeaders = []string{
+	"Content-Type",
+	"Content-Disposition",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+	"Expires",
+}
+
+// DiskCache 基于磁盘的下载缓存，超出容量时按LRU策略淘汰
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List // 队首为最近使用的条目
+	mu      sync.Mutex
+}
+
+// cacheEntry 描述一个已完成的缓存条目
+type cacheEntry struct {
+	Key      string      `json:"key"`
+	URL      string      `json:"url"`
+	Size     int64       `json:"size"`
+	Header   http.Header `json:"header"`
+	StoredAt time.Time   `json:"storedAt"`
+}
+
+// NewDiskCache 创建磁盘缓存，并加载目录中已有的缓存条目
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录失败: %v", err)
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	c.load()
+	return c, nil
+}
+
+// load 从磁盘恢复缓存索引，按数据文件的修改时间还原LRU顺序
+func (c *DiskCache) load() {
+	// 清理上次运行遗留的临时文件
+	if tmpFiles, err := os.ReadDir(filepath.Join(c.dir, "tmp")); err == nil {
+		for _, f := range tmpFiles {
+			os.Remove(filepath.Join(c.dir, "tmp", f.Name()))
+		}
+	}
+
+	metaFiles, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return
+	}
+
+	type loaded struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var items []loaded
+
+	for _, metaPath := range metaFiles {
+		data, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		entry := &cacheEntry{}
+		if err := json.Unmarshal(data, entry); err != nil || entry.Key == "" {
+			os.Remove(metaPath)
+			continue
+		}
+		stat, err := os.Stat(c.dataPath(entry.Key))
+		if err != nil || stat.Size() != entry.Size {
+			// 数据文件缺失或不完整，丢弃该条目
+			c.removeFiles(entry.Key)
+			continue
+		}
+		items = append(items, loaded{entry: entry, modTime: stat.ModTime()})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].modTime.After(items[j].modTime)
+	})
+	for _, item := range items {
+		c.entries[item.entry.Key] = c.lru.PushBack(item.entry)
+		c.size += item.entry.Size
+	}
+	c.evict(0)
+
+	if len(c.entries) > 0 {
+		slog.Info("已加载磁盘缓存", "files", len(c.entries), "bytes", c.size)
+	}
+}
+
+// Get 查找缓存条目，命中时返回条目和已打开的数据文件
+func (c *DiskCache) Get(key string) (*cacheEntry, *os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	f, err := os.Open(c.dataPath(key))
+	if err != nil {
+		c.removeLocked(elem)
+		return nil, nil, false
+	}
+
+	// 更新LRU顺序，同时刷新文件时间以便重启后恢复
+	c.lru.MoveToFront(elem)
+	now := time.Now()
+	os.Chtimes(c.dataPath(key), now, now)
+
+	return entry, f, true
+}
+
+// Refresh 在上游返回304后更新缓存条目的响应头和存储时间
+func (c *DiskCache) Refresh(entry *cacheEntry, header http.Header) *cacheEntry {
+	refreshed := &cacheEntry{
+		Key:      entry.Key,
+		URL:      entry.URL,
+		Size:     entry.Size,
+		Header:   entry.Header.Clone(),
+		StoredAt: time.Now(),
+	}
+	for _, name := range cachedHeaders {
+		if value := header.Get(name); value != "" {
+			refreshed.Header.Set(name, value)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 条目不可变，用新对象替换以免影响正在读取的请求
+	elem, ok := c.entries[entry.Key]
+	if !ok {
+		return refreshed
+	}
+	elem.Value = refreshed
+
+	if meta, err := json.Marshal(refreshed); err == nil {
+		os.WriteFile(c.metaPath(entry.Key), meta, 0644)
+	}
+	return refreshed
+}
+
+// Remove 删除指定的缓存条目
+func (c *DiskCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+}
+
+// NewWriter 为指定的键创建缓存写入器，数据先写入临时文件
+func (c *DiskCache) NewWriter(key string, rawURL string) (*cacheWriter, error) {
+	f, err := os.CreateTemp(filepath.Join(c.dir, "tmp"), key+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{
+		cache: c,
+		key:   key,
+		url:   rawURL,
+		file:  f,
+	}, nil
+}
+
+// commit 将临时文件移入缓存目录并登记条目
+func (c *DiskCache) commit(entry *cacheEntry, tmpPath string) error {
+	if entry.Size > c.maxSize {
+		os.Remove(tmpPath)
+		return fmt.Errorf("文件大小超出缓存容量")
+	}
+
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 覆盖同键的旧条目
+	if elem, ok := c.entries[entry.Key]; ok {
+		c.removeLocked(elem)
+	}
+
+	if err := os.Rename(tmpPath, c.dataPath(entry.Key)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.WriteFile(c.metaPath(entry.Key), meta, 0644); err != nil {
+		os.Remove(c.dataPath(entry.Key))
+		return err
+	}
+
+	c.evict(entry.Size)
+	c.entries[entry.Key] = c.lru.PushFront(entry)
+	c.size += entry.Size
+	return nil
+}
+
+// evict 淘汰最久未使用的条目，直到能容纳新增的字节数
+func (c *DiskCache) evict(incoming int64) {
+	for c.size+incoming > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		slog.Info("缓存淘汰", "target_url", entry.URL, "bytes", entry.Size)
+		c.removeLocked(elem)
+	}
+}
+
+// removeLocked 删除缓存条目，调用方需持有锁
+func (c *DiskCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.Key)
+	c.size -= entry.Size
+	c.removeFiles(entry.Key)
+}
+
+// removeFiles 删除缓存条目对应的文件
+func (c *DiskCache) removeFiles(key string) {
+	os.Remove(c.dataPath(key))
+	os.Remove(c.metaPath(key))
+}
+
+func (c *DiskCache) dataPath(key string) string {
+	return filepath.Join(c.dir, key+".data")
+}
+
+func (c *DiskCache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// cacheWriter 在流式传输的同时将响应体写入缓存临时文件
+type cacheWriter struct {
+	cache   *DiskCache
+	key     string
+	url     string
+	file    *os.File
+	written int64
+	failed  bool
+}
+
+// Write 实现io.Writer接口
+// 写入磁盘失败时只标记失败，不影响客户端的流式传输
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.failed {
+		return len(p), nil
+	}
+	n, err := cw.file.Write(p)
+	cw.written += int64(n)
+	if err != nil {
+		slog.Error("写入缓存失败", "target_url", cw.url, "error", err)
+		cw.failed = true
+	}
+	return len(p), nil
+}
+
+// Commit 在传输完整结束后保存缓存条目
+func (cw *cacheWriter) Commit(resp *http.Response) {
+	tmpPath := cw.file.Name()
+	cw.file.Close()
+
+	if cw.failed || (resp.ContentLength >= 0 && cw.written != resp.ContentLength) {
+		os.Remove(tmpPath)
+		return
+	}
+
+	entry := &cacheEntry{
+		Key:      cw.key,
+		URL:      cw.url,
+		Size:     cw.written,
+		Header:   make(http.Header),
+		StoredAt: time.Now(),
+	}
+	for _, name := range cachedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			entry.Header.Set(name, value)
+		}
+	}
+
+	if err := cw.cache.commit(entry, tmpPath); err != nil {
+		slog.Error("保存缓存失败", "target_url", cw.url, "error", err)
+		return
+	}
+	slog.Info("已缓存", "target_url", cw.url, "bytes", cw.written)
+}
+
+// Abort 放弃本次缓存写入
+func (cw *cacheWriter) Abort() {
+	cw.file.Close()
+	os.Remove(cw.file.Name())
+}
+
+// response 将缓存条目还原为响应对象，便于复用响应头处理逻辑
+func (e *cacheEntry) response() *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        e.Header.Clone(),
+		ContentLength: e.Size,
+	}
+}
+
+// modTime 返回用于条件请求的最后修改时间
+func (e *cacheEntry) modTime() time.Time {
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		if t, err := http.ParseTime(lastModified); err == nil {
+			return t
+		}
+	}
+	return e.StoredAt
+}
+
+// isFresh 判断缓存条目是否仍在有效期内
+func (e *cacheEntry) isFresh(ttl time.Duration) bool {
+	return time.Since(e.StoredAt) < ttl
+}
+
+// setRevalidationHeaders 用缓存条目的ETag和Last-Modified替换请求中的条件头
+func setRevalidationHeaders(req *http.Request, entry *cacheEntry) {
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// cacheTTL 返回目标主机适用的缓存有效期，按配置顺序匹配第一条规则
+func (p *ProxyHandler) cacheTTL(targetURL *url.URL) time.Duration {
+	host := strings.ToLower(targetURL.Hostname())
+	for _, rule := range p.config.Proxy.Cache.TTLRules {
+		if matchHostPattern(host, rule.Host) {
+			return time.Duration(rule.TTL) * time.Second
+		}
+	}
+	return time.Duration(p.config.Proxy.Cache.DefaultTTL) * time.Second
+}
+
+// cacheKey 根据规范化后的目标URL生成缓存键
+func cacheKey(targetURL *url.URL) string {
+	u := *targetURL
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// 去掉默认端口
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" &&
+		!(u.Scheme == "http" && port == "80") &&
+		!(u.Scheme == "https" && port == "443") {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	// 查询参数按键排序
+	u.RawQuery = u.Query().Encode()
+
+	sum := sha256.Sum256([]byte(u.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// isCacheableResponse 判断上游响应是否可以写入缓存
+func isCacheableResponse(r *http.Request, resp *http.Response) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return false
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	// 压缩编码的响应体依赖客户端的Accept-Encoding，不做缓存
+	if resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	cacheControl := strings.ToLower(resp.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return false
+	}
+	// 携带凭据的请求，只有上游明确允许共享时才缓存(RFC 9111 3.5)
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return strings.Contains(cacheControl, "public") ||
+			strings.Contains(cacheControl, "s-maxage") ||
+			strings.Contains(cacheControl, "must-revalidate")
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+// notModifiedHeaders 返回304时需要删除的描述响应体的头
+var notModifiedHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Disposition",
+	"Content-Range",
+}
+
+// cacheHeaderPolicy 决定返回给客户端的缓存相关响应头
+// 匹配规则的成功响应使用规则中的Cache-Control，其余响应在passthrough模式下保留上游的缓存头，
+// 在noCache模式下禁止客户端缓存
+type cacheHeaderPolicy struct {
+	noCache bool
+	rules   []cacheHeaderRule
+}
+
+// cacheHeaderRule 编译后的缓存头规则
+type cacheHeaderRule struct {
+	host         *hostRule // 为空时匹配所有主机
+	path         *regexp.Regexp
+	cacheControl string
+}
+
+// newCacheHeaderPolicy 根据配置创建缓存头策略
+func newCacheHeaderPolicy(cfg *config.Config) (*cacheHeaderPolicy, error) {
+	cp := &cacheHeaderPolicy{noCache: cfg.Proxy.CacheHeaders.Mode == config.CacheHeadersNoCache}
+
+	for _, rule := range cfg.Proxy.CacheHeaders.Rules {
+		name := rule.Name
+		if name == "" {
+			name = rule.Host + rule.Path
+		}
+		compiled := cacheHeaderRule{cacheControl: rule.CacheControl}
+		if rule.Host != "" {
+			hosts, err := compileHostRules([]config.HostRule{{Name: name, Pattern: rule.Host}})
+			if err != nil {
+				return nil, err
+			}
+			compiled.host = &hosts[0]
+		}
+		if rule.Path != "" {
+			re, err := compilePathPattern(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("缓存头规则 %q 的路径无效: %v", name, err)
+			}
+			compiled.path = re
+		}
+		cp.rules = append(cp.rules, compiled)
+	}
+	return cp, nil
+}
+
+// compilePathPattern 将路径模式编译为正则表达式，"*"匹配包括"/"在内的任意字符，
+// 如"*/releases/download/*"匹配GitHub所有仓库的发布文件
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		return regexp.Compile(expr)
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return regexp.Compile("^" + expr + "$")
+}
+
+// match 返回第一条匹配目标URL的规则
+func (cp *cacheHeaderPolicy) match(targetURL *url.URL) *cacheHeaderRule {
+	host := normalizeHost(targetURL.Hostname())
+	for i := range cp.rules {
+		rule := &cp.rules[i]
+		if rule.host != nil && !rule.host.match(host) {
+			continue
+		}
+		if rule.path != nil && !rule.path.MatchString(targetURL.Path) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// apply 按策略设置响应头，status为返回给客户端的状态码
+// 规则只对成功的响应生效，避免客户端长期缓存上游的错误页面
+func (cp *cacheHeaderPolicy) apply(h http.Header, status int, targetURL *url.URL) {
+	success := status == http.StatusOK || status == http.StatusPartialContent || status == http.StatusNotModified
+	if rule := cp.match(targetURL); rule != nil && success {
+		h.Set("Cache-Control", rule.cacheControl)
+		// Cache-Control的max-age优先于Expires，删除以免与规则矛盾
+		h.Del("Expires")
+		h.Del("Pragma")
+		return
+	}
+	if cp.noCache {
+		h.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		h.Set("Pragma", "no-cache")
+		h.Set("Expires", "0")
+	}
+}
+
+// notModified 按客户端的条件请求头判断响应是否未修改(RFC 9110 13.2.2)
+// If-None-Match存在时忽略If-Modified-Since，两者都只对GET和HEAD请求生效
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := h.Get("ETag")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// If-None-Match使用弱比较
+			if candidate == "*" || etag != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	lastModified := h.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// writeNotModified 客户端缓存的副本仍然有效时返回304
+// 用于上游忽略了条件请求头，或者条件头被缓存验证替换而上游返回了完整响应的情况
+func (p *ProxyHandler) writeNotModified(w http.ResponseWriter, r *http.Request, resp *http.Response, targetURL *url.URL) {
+	notModifiedResp := *resp
+	notModifiedResp.StatusCode = http.StatusNotModified
+	p.writeResponseHeaders(w, r, &notModifiedResp, targetURL, "")
+	for _, name := range notModifiedHeaders {
+		w.Header().Del(name)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yourusername/proxy-service/tracing"
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// flightGroup 合并同一URL的并发下载，只向上游发起一次请求
+type flightGroup struct {
+	spoolDir string
+	flights  map[string]*flight
+	mu       sync.Mutex
+}
+
+// flight 表示一次正在进行的上游下载
+// 上游响应体写入临时文件，所有客户端（包括发起者）都从该文件追读
+type flight struct {
+	key   string
+	group *flightGroup
+	ready chan struct{} // 上游响应头就绪或确认无法共享时关闭
+	resp  *http.Response
+
+	reader *os.File // 只读句柄，文件被重命名或删除后仍然可读
+	sink   *spool
+	cancel context.CancelFunc
+
+	written  int64
+	done     bool
+	started  bool
+	canceled bool // 所有读取方都已离开，上游传输被取消
+	err      error
+	readers  int
+	mu       sync.Mutex
+	cond     *sync.Cond
+}
+
+// newFlightGroup 创建下载合并组
+func newFlightGroup(spoolDir string) (*flightGroup, error) {
+	if spoolDir == "" {
+		spoolDir = os.TempDir()
+	}
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %v", err)
+	}
+	return &flightGroup{
+		spoolDir: spoolDir,
+		flights:  make(map[string]*flight),
+	}, nil
+}
+
+// join 加入指定键的下载，第二个返回值表示调用方是否为发起者
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if fl, ok := g.flights[key]; ok {
+		fl.mu.Lock()
+		canceled := fl.canceled
+		if !canceled {
+			fl.readers++
+		}
+		fl.mu.Unlock()
+		// 已取消的下载即将移出合并组，不能再加入，否则只能读到截断的响应体
+		if !canceled {
+			return fl, false
+		}
+	}
+
+	fl := &flight{
+		key:     key,
+		group:   g,
+		ready:   make(chan struct{}),
+		readers: 1,
+	}
+	fl.cond = sync.NewCond(&fl.mu)
+	g.flights[key] = fl
+	return fl, true
+}
+
+// remove 将下载从合并组中移除，之后的请求会发起新的下载
+func (g *flightGroup) remove(fl *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.flights[fl.key] == fl {
+		delete(g.flights, fl.key)
+	}
+}
+
+// abandon 由发起者调用，表示上游响应无法共享，等待中的请求需各自请求上游
+func (fl *flight) abandon() {
+	fl.group.remove(fl)
+	close(fl.ready)
+}
+
+// start 发布上游响应并在后台使用buffers的缓冲区将响应体写入临时文件
+func (fl *flight) start(resp *http.Response, sink *spool, cancel context.CancelFunc, buffers bufferPolicy) error {
+	reader, err := os.Open(sink.path)
+	if err != nil {
+		return err
+	}
+
+	fl.mu.Lock()
+	fl.resp = resp
+	fl.reader = reader
+	fl.sink = sink
+	fl.cancel = cancel
+	fl.started = true
+	fl.mu.Unlock()
+	close(fl.ready)
+
+	go func() {
+		_, err := buffers.copy(flightWriter{fl}, resp.Body, resp.ContentLength)
+		resp.Body.Close()
+		fl.finish(err)
+	}()
+	return nil
+}
+
+// wait 等待发起者的上游响应，返回响应是否可以共享
+func (fl *flight) wait(ctx context.Context) bool {
+	select {
+	case <-fl.ready:
+		return fl.resp != nil
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// finish 在上游传输结束后保存结果并唤醒所有读取方
+func (fl *flight) finish(err error) {
+	fl.group.remove(fl)
+	fl.sink.finish(fl.resp, err)
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	fl.done = true
+	fl.err = err
+	fl.cancel()
+	fl.cond.Broadcast()
+	if fl.readers == 0 {
+		fl.cleanup()
+	}
+}
+
+// leave 读取方离开，最后一个读取方离开时停止未完成的上游传输
+func (fl *flight) leave() {
+	fl.mu.Lock()
+	fl.readers--
+	if fl.readers > 0 || !fl.started {
+		fl.mu.Unlock()
+		return
+	}
+	if fl.done {
+		fl.cleanup()
+		fl.mu.Unlock()
+		return
+	}
+	fl.canceled = true
+	fl.mu.Unlock()
+
+	// 先移出合并组再取消，之后的请求发起新的下载
+	// join先锁合并组再锁下载，这里释放下载的锁后再调用remove，避免死锁
+	fl.group.remove(fl)
+	fl.cancel()
+}
+
+// cleanup 关闭只读句柄并删除临时文件，调用方需持有锁
+func (fl *flight) cleanup() {
+	fl.reader.Close()
+	fl.sink.remove()
+}
+
+// broadcast 唤醒所有等待数据的读取方
+func (fl *flight) broadcast() {
+	fl.mu.Lock()
+	fl.cond.Broadcast()
+	fl.mu.Unlock()
+}
+
+// newReader 创建从头开始追读临时文件的读取器
+func (fl *flight) newReader(ctx context.Context) *flightReader {
+	return &flightReader{fl: fl, ctx: ctx}
+}
+
+// flightWriter 将上游数据写入临时文件并通知读取方
+type flightWriter struct {
+	fl *flight
+}
+
+// Write 实现io.Writer接口
+func (fw flightWriter) Write(p []byte) (int, error) {
+	n, err := fw.fl.sink.Write(p)
+
+	fw.fl.mu.Lock()
+	fw.fl.written += int64(n)
+	fw.fl.cond.Broadcast()
+	fw.fl.mu.Unlock()
+
+	return n, err
+}
+
+// flightReader 从临时文件中读取已下载的数据，数据不足时等待上游写入
+type flightReader struct {
+	fl  *flight
+	ctx context.Context
+	off int64
+}
+
+// Read 实现io.Reader接口
+func (fr *flightReader) Read(p []byte) (int, error) {
+	fl := fr.fl
+
+	fl.mu.Lock()
+	for fr.off >= fl.written && !fl.done && fr.ctx.Err() == nil {
+		fl.cond.Wait()
+	}
+	written, done, flightErr := fl.written, fl.done, fl.err
+	fl.mu.Unlock()
+
+	if fr.off < written {
+		if remaining := written - fr.off; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+		n, err := fl.reader.ReadAt(p, fr.off)
+		fr.off += int64(n)
+		if errors.Is(err, io.EOF) && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+
+	if err := fr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if done && flightErr != nil {
+		return 0, flightErr
+	}
+	return 0, io.EOF
+}
+
+// spool 上游响应体的临时文件
+// 启用磁盘缓存时直接写入缓存临时文件，传输完成后提交为缓存条目
+type spool struct {
+	path string
+	file *os.File
+	cw   *cacheWriter
+}
+
+// newSpool 为合并下载创建临时文件
+func (p *ProxyHandler) newSpool(targetURL *url.URL, proxyReq *http.Request, resp *http.Response) (*spool, error) {
+	if p.cache != nil && isCacheableResponse(proxyReq, resp) {
+		cw, err := p.cache.NewWriter(cacheKey(targetURL), targetURL.String())
+		if err != nil {
+			return nil, err
+		}
+		return &spool{path: cw.file.Name(), cw: cw}, nil
+	}
+
+	f, err := os.CreateTemp(p.flights.spoolDir, "dl-proxy-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{path: f.Name(), file: f}, nil
+}
+
+// Write 实现io.Writer接口
+func (s *spool) Write(p []byte) (int, error) {
+	if s.cw == nil {
+		return s.file.Write(p)
+	}
+	// 缓存写入器会吞掉磁盘错误，这里需要让读取方感知
+	n, _ := s.cw.Write(p)
+	if s.cw.failed {
+		return 0, fmt.Errorf("写入临时文件失败")
+	}
+	return n, nil
+}
+
+// finish 在传输结束后关闭写入句柄，完整的响应提交到缓存
+func (s *spool) finish(resp *http.Response, err error) {
+	if s.cw == nil {
+		s.file.Close()
+		return
+	}
+	if err == nil {
+		s.cw.Commit(resp)
+	} else {
+		s.cw.Abort()
+	}
+}
+
+// remove 删除不再需要的临时文件，已提交到缓存的文件不受影响
+func (s *spool) remove() {
+	if s.cw == nil {
+		os.Remove(s.path)
+	}
+}
+
+// isCoalescable 判断请求能否与其他请求合并
+// 只有不带Range和条件头的GET请求才会共享同一个上游响应
+func isCoalescable(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Range") == "" &&
+		r.Header.Get("If-None-Match") == "" &&
+		r.Header.Get("If-Modified-Since") == ""
+}
+
+// isShareableResponse 判断上游响应能否提供给其他请求
+func isShareableResponse(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Encoding") == ""
+}
+
+// serveCoalesced 合并处理同一URL的并发下载
+func (p *ProxyHandler) serveCoalesced(w http.ResponseWriter, r *http.Request, targetURL *url.URL, clientIP string) {
+	fl, leader := p.flights.join(cacheKey(targetURL))
+	defer fl.leave()
+
+	if !leader {
+		if fl.wait(r.Context()) {
+			slog.InfoContext(r.Context(), "合并下载: 加入进行中的下载", "client_ip", clientIP, "target_url", targetURL.String())
+			p.serveFlight(w, r, targetURL, fl, clientIP)
+			return
+		}
+		// 发起者的响应无法共享，独立请求上游
+		p.forward(w, r, targetURL, clientIP, nil, nil)
+		return
+	}
+
+	// 上游传输不随发起者断开而中止，由所有读取方共同决定
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Duration(p.config.Proxy.TransferTimeout)*time.Second)
+
+	proxyReq, err, reqCancel := p.createProxyRequest(r.WithContext(ctx), targetURL)
+	if err != nil {
+		cancel()
+		fl.abandon()
+		utils.HTTPError(w, r, fmt.Sprintf("创建代理请求失败: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "创建代理请求失败", "client_ip", clientIP, "target_url", targetURL.String(), "error", err)
+		return
+	}
+	stop := func() {
+		reqCancel()
+		cancel()
+	}
+
+	resp, err := p.client.Do(proxyReq)
+	if err != nil {
+		stop()
+		fl.abandon()
+		writeUpstreamError(w, r, targetURL, clientIP, err)
+		return
+	}
+	p.maybeResumable(proxyReq, resp)
+	p.maybeSegment(proxyReq, resp)
+
+	if isShareableResponse(resp) {
+		sink, err := p.newSpool(targetURL, proxyReq, resp)
+		if err == nil {
+			if err = fl.start(resp, sink, stop, p.buffers); err == nil {
+				p.serveFlight(w, r, targetURL, fl, clientIP)
+				return
+			}
+			sink.finish(resp, err)
+			sink.remove()
+		}
+		slog.ErrorContext(r.Context(), "创建临时文件失败，不合并下载", "client_ip", clientIP, "target_url", targetURL.String(), "error", err)
+	}
+
+	// 无法共享的响应按普通方式转发
+	fl.abandon()
+	defer stop()
+	defer resp.Body.Close()
+	p.streamResponse(w, r, targetURL, proxyReq, resp, clientIP)
+}
+
+// serveFlight 从合并下载的临时文件向客户端流式传输
+func (p *ProxyHandler) serveFlight(w http.ResponseWriter, r *http.Request, targetURL *url.URL, fl *flight, clientIP string) {
+	resp := fl.resp
+	fileName := extractFilenameFromURL(targetURL)
+
+	p.writeResponseHeaders(w, r, resp, targetURL, fileName)
+	if p.cache != nil {
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	writer := newTrackedWriter(w, r, targetURL.String(), fileName, clientIP, p.bandwidth)
+	writer.WriteHeader(resp.StatusCode)
+
+	// 客户端断开时唤醒等待中的读取
+	stopWake := context.AfterFunc(r.Context(), fl.broadcast)
+	defer stopWake()
+
+	_, span := tracing.Start(r.Context(), "body.stream", tracing.KindInternal)
+	n, err := p.buffers.copy(writer, fl.newReader(r.Context()), resp.ContentLength)
+	span.SetAttributes("bytes", n, "coalesced", true)
+	span.SetError(err)
+	span.End()
+
+	writer.finish(err)
+}
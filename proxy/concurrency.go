@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// connLimits 同时进行的传输数限制，0表示不限制
+type connLimits struct {
+	perClient int
+	perHost   int
+	global    int
+}
+
+// connLimiter 限制同时进行的代理传输数，计数由downloadTracker维护
+// 超限时根据配置直接拒绝，或排队等待其他传输结束
+type connLimiter struct {
+	limits       connLimits
+	queue        bool
+	queueTimeout time.Duration
+}
+
+// connLimitError 表示并发传输数超过限制
+type connLimitError struct {
+	scope string
+	limit int
+}
+
+func (e *connLimitError) Error() string {
+	switch e.scope {
+	case "client":
+		return fmt.Sprintf("并发连接数超限: 每个客户端最多%d个", e.limit)
+	case "host":
+		return fmt.Sprintf("并发连接数超限: 每个目标主机最多%d个", e.limit)
+	default:
+		return fmt.Sprintf("并发连接数超限: 全局最多%d个", e.limit)
+	}
+}
+
+// newConnLimiter 创建并发传输限制器，所有限制都未开启时返回nil
+func newConnLimiter(limits connLimits, mode string, queueTimeout int) (*connLimiter, error) {
+	if limits.perClient <= 0 && limits.perHost <= 0 && limits.global <= 0 {
+		return nil, nil
+	}
+
+	cl := &connLimiter{
+		limits:       limits,
+		queueTimeout: time.Duration(queueTimeout) * time.Second,
+	}
+	switch mode {
+	case "", "reject":
+	case "queue":
+		cl.queue = true
+	default:
+		return nil, fmt.Errorf("不支持的并发限制模式: %s", mode)
+	}
+	return cl, nil
+}
+
+// acquire 登记一个传输，返回传输结束时调用的释放函数
+// 排队模式下最多等待queueTimeout，超时或客户端断开时返回错误
+func (cl *connLimiter) acquire(ctx context.Context, clientIP, host string) (func(), error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	host = normalizeHost(host)
+
+	var timeout <-chan time.Time
+	if cl.queue && cl.queueTimeout > 0 {
+		timer := time.NewTimer(cl.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		scope, released := downloadTracker.tryAcquireConn(clientIP, host, cl.limits)
+		if scope == "" {
+			return func() { downloadTracker.releaseConn(clientIP, host) }, nil
+		}
+		if !cl.queue {
+			return nil, cl.limitError(scope)
+		}
+
+		select {
+		case <-released:
+		case <-timeout:
+			return nil, cl.limitError(scope)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// limitError 创建指定范围的超限错误
+func (cl *connLimiter) limitError(scope string) *connLimitError {
+	limit := cl.limits.global
+	switch scope {
+	case "client":
+		limit = cl.limits.perClient
+	case "host":
+		limit = cl.limits.perHost
+	}
+	return &connLimitError{scope: scope, limit: limit}
+}
@@ -3,19 +3,22 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/yourusername/proxy-service/config"
+	"github.com/yourusername/proxy-service/tracing"
 	"github.com/yourusername/proxy-service/utils"
 )
 
@@ -23,220 +26,133 @@ const (
 	maxResponseHeaderBytes = 1 << 20         // 1MB
 	maxUrlLength           = 8 * 1024        // 8KB
 	proxyIdentifier        = "GoStreamProxy" // 代理服务器标识
-	nodeID                 = "node1"         // 节点标识
 )
 
 var (
 	// 提取URL的正则表达式
 	urlExtractor = regexp.MustCompile(`^/(https?:/?/?)([-a-zA-Z0-9@:%._\+~#=]{1,256}(?:\.[-a-zA-Z0-9()]{1,6})+(?:[-a-zA-Z0-9()@:%_\+.~#?&//=]*))$`)
 
-	// RFC1918 私有地址检测正则
-	privateIPRegex = regexp.MustCompile(`^(127\.|10\.|172\.(1[6-9]|2[0-9]|3[0-1])\.|192\.168\.)`)
-
-	// 内存缓冲池
-	bufferPool = utils.NewBufferPool(32 * 1024) // 32KB 缓冲区
+	// 内存缓冲池，按缓冲区大小分别复用，大小由各处理器的缓冲区策略决定
+	bufferPool = utils.NewSizedBufferPool()
 
 	// 下载跟踪器，用于合并分片下载的日志
 	downloadTracker = NewDownloadTracker()
-
-	// 需要删除的敏感头列表
-	sensitiveHeaders = []string{
-		"Authorization",
-		"Cookie",
-		"Set-Cookie",
-	}
 )
 
-// DownloadTracker 用于跟踪文件下载状态
-type DownloadTracker struct {
-	downloads map[string]*DownloadInfo
-	mu        sync.RWMutex
-}
-
-// DownloadInfo 存储下载信息
-type DownloadInfo struct {
-	fileName     string
-	totalSize    int64
-	bytesWritten int64
-	startTime    time.Time
-	lastLogTime  time.Time
-	isComplete   bool
-	activeConns  int
-	clientIP     string
-	mu           sync.Mutex
+type ProxyHandler struct {
+	client  *http.Client
+	config  *config.Config
+	cache   *DiskCache
+	flights *flightGroup
+
+	netPolicy  *networkPolicy
+	hostPolicy *hostPolicy
+	headers    *headerPolicy
+	caching    *cacheHeaderPolicy
+	bandwidth  *bandwidthLimiter
+	conns      *connLimiter
+	buffers    bufferPolicy
 }
 
-// NewDownloadTracker 创建新的下载跟踪器
-func NewDownloadTracker() *DownloadTracker {
-	return &DownloadTracker{
-		downloads: make(map[string]*DownloadInfo),
+// NewProxyHandler 创建新的代理处理器
+func NewProxyHandler(cfg *config.Config) (*ProxyHandler, error) {
+	handler, err := newProxyHandler(cfg)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// GetOrCreate 获取或创建下载信息
-func (dt *DownloadTracker) GetOrCreate(url string, fileName string, totalSize int64, clientIP string) *DownloadInfo {
-	dt.mu.Lock()
-	defer dt.mu.Unlock()
-
-	// 使用URL作为唯一标识
-	key := url
-
-	info, exists := dt.downloads[key]
-	if !exists {
-		info = &DownloadInfo{
-			fileName:     fileName,
-			totalSize:    totalSize,
-			bytesWritten: 0,
-			startTime:    time.Now(),
-			lastLogTime:  time.Now(),
-			isComplete:   false,
-			activeConns:  0,
-			clientIP:     clientIP,
+	// 初始化磁盘缓存
+	if cfg.Proxy.Cache.Enabled {
+		cache, err := NewDiskCache(cfg.Proxy.Cache.Dir, cfg.Proxy.Cache.MaxSize)
+		if err != nil {
+			slog.Error("初始化磁盘缓存失败，缓存已禁用", "error", err)
+		} else {
+			handler.cache = cache
 		}
-		dt.downloads[key] = info
-
-		// 记录下载开始
-		log.Printf("客户端: %s | 开始下载: %s, 预计大小: %s",
-			clientIP,
-			fileName,
-			formatFileSize(totalSize))
 	}
 
-	// 增加活跃连接计数
-	info.mu.Lock()
-	info.activeConns++
-	info.mu.Unlock()
+	// 初始化下载合并
+	if cfg.Proxy.Coalescing.Enabled {
+		flights, err := newFlightGroup(cfg.Proxy.Coalescing.SpoolDir)
+		if err != nil {
+			slog.Error("初始化下载合并失败，已禁用", "error", err)
+		} else {
+			handler.flights = flights
+		}
+	}
 
-	return info
+	return handler, nil
 }
 
-// UpdateProgress 更新下载进度
-func (di *DownloadInfo) UpdateProgress(bytesAdded int64) {
-	di.mu.Lock()
-	defer di.mu.Unlock()
-
-	di.bytesWritten += bytesAdded
-
-	// 每10秒记录一次进度
-	now := time.Now()
-	if now.Sub(di.lastLogTime) >= 10*time.Second && !di.isComplete {
-		di.logProgress()
-		di.lastLogTime = now
+// Reload 根据新配置创建处理器，用于重新加载配置
+// 磁盘缓存和下载合并沿用当前的实例，带宽限制未变化时沿用原有状态，变化时保留各客户端当日已用的流量
+// 已经开始的请求继续使用原处理器的配置完成
+func (p *ProxyHandler) Reload(cfg *config.Config) (*ProxyHandler, error) {
+	handler, err := newProxyHandler(cfg)
+	if err != nil {
+		return nil, err
 	}
-}
 
-// logProgress 记录当前下载进度
-func (di *DownloadInfo) logProgress() {
-	elapsedTime := time.Since(di.startTime)
-	speedMBps := float64(di.bytesWritten) / elapsedTime.Seconds() / 1024 / 1024
-
-	if di.totalSize > 0 {
-		percentage := float64(di.bytesWritten) * 100 / float64(di.totalSize)
-		log.Printf("客户端: %s | 下载进度: %s, %.2f%% (已下载: %s / 总大小: %s), 速度: %.2f MB/s, 活跃连接: %d",
-			di.clientIP,
-			di.fileName,
-			percentage,
-			formatFileSize(di.bytesWritten),
-			formatFileSize(di.totalSize),
-			speedMBps,
-			di.activeConns)
+	handler.cache = p.cache
+	handler.flights = p.flights
+	if cfg.Security.Bandwidth == p.config.Security.Bandwidth {
+		handler.bandwidth = p.bandwidth
 	} else {
-		log.Printf("客户端: %s | 下载进度: %s, 已下载: %s, 速度: %.2f MB/s, 活跃连接: %d",
-			di.clientIP,
-			di.fileName,
-			formatFileSize(di.bytesWritten),
-			speedMBps,
-			di.activeConns)
+		handler.bandwidth.inheritUsage(p.bandwidth)
 	}
+	return handler, nil
 }
 
-// ConnectionClosed 标记一个连接已关闭
-func (dt *DownloadTracker) ConnectionClosed(url string, err error) bool {
-	dt.mu.RLock()
-	info, exists := dt.downloads[url]
-	dt.mu.RUnlock()
+// CloseIdleConnections 关闭与上游的空闲连接，重新加载配置后用于释放旧处理器的连接
+func (p *ProxyHandler) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
 
-	if !exists {
-		return false
+// newProxyHandler 根据配置创建处理器，不包括磁盘缓存和下载合并
+func newProxyHandler(cfg *config.Config) (*ProxyHandler, error) {
+	// 地址校验策略，在每次拨号时检查实际连接的IP
+	netPolicy, err := newNetworkPolicy(
+		cfg.Security.PrivateIPBlocking,
+		cfg.Security.Network.DenyCIDRs,
+		cfg.Security.Network.AllowCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("解析网络访问策略失败: %v", err)
 	}
 
-	info.mu.Lock()
-	defer info.mu.Unlock()
-
-	// 减少活跃连接计数
-	info.activeConns--
-
-	// 如果还有活跃连接，则不是最终状态
-	if info.activeConns > 0 {
-		return false
+	// 目标主机的允许和禁止规则
+	hostPolicy, err := newHostPolicy(cfg.Security.Hosts.Allow, cfg.Security.Hosts.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("解析主机规则失败: %v", err)
 	}
 
-	// 所有连接都已关闭，判断是否完成下载
-	if err == nil || info.bytesWritten >= info.totalSize {
-		// 下载完成
-		if !info.isComplete {
-			info.isComplete = true
-			downloadDuration := time.Since(info.startTime)
-			speedMBps := float64(info.bytesWritten) / downloadDuration.Seconds() / 1024 / 1024
-
-			log.Printf("客户端: %s | 下载完成: %s, 大小: %s, 耗时: %.2f秒, 速度: %.2f MB/s",
-				info.clientIP,
-				info.fileName,
-				formatFileSize(info.bytesWritten),
-				downloadDuration.Seconds(),
-				speedMBps)
-		}
-		return true
-	} else if isClientDisconnectError(err) {
-		// 客户端取消下载
-		if !info.isComplete {
-			info.isComplete = true
-			log.Printf("客户端: %s | 下载取消: %s - 客户端断开连接",
-				info.clientIP,
-				info.fileName)
-		}
-		return true
-	} else {
-		// 下载出错
-		if !info.isComplete {
-			info.isComplete = true
-			log.Printf("客户端: %s | 下载错误: %s - %v",
-				info.clientIP,
-				info.fileName,
-				err)
-		}
-		return true
+	// 请求头和响应头的处理规则
+	headers, err := newHeaderPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("解析头部规则失败: %v", err)
 	}
-}
 
-// CleanupOldDownloads 清理完成超过一定时间的下载记录
-func (dt *DownloadTracker) CleanupOldDownloads() {
-	dt.mu.Lock()
-	defer dt.mu.Unlock()
+	// 返回给客户端的缓存头
+	caching, err := newCacheHeaderPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("解析缓存头规则失败: %v", err)
+	}
 
-	threshold := time.Now().Add(-30 * time.Minute)
-	for url, info := range dt.downloads {
-		info.mu.Lock()
-		if info.isComplete && info.lastLogTime.Before(threshold) {
-			delete(dt.downloads, url)
+	// 经过转发代理时拨号校验只能看到代理的地址，无法校验实际的目标，启用地址校验时不使用环境变量中的代理
+	proxyFunc := http.ProxyFromEnvironment
+	if netPolicy.enabled() {
+		proxyFunc = nil
+		if envProxyConfigured() {
+			slog.Warn("已启用目标地址校验，忽略环境变量中的HTTP代理设置")
 		}
-		info.mu.Unlock()
 	}
-}
 
-type ProxyHandler struct {
-	client *http.Client
-	config *config.Config
-}
-
-// NewProxyHandler 创建新的代理处理器
-func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 	// 配置传输层
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: proxyFunc,
 		DialContext: (&net.Dialer{
 			Timeout:   time.Duration(cfg.Proxy.ConnectTimeout) * time.Second,
 			KeepAlive: 30 * time.Second,
+			Control:   netPolicy.control,
 		}).DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
@@ -250,32 +166,58 @@ func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 		},
 	}
 
+	// 同时进行的传输数限制
+	concurrency := cfg.Security.Concurrency
+	conns, err := newConnLimiter(connLimits{
+		perClient: concurrency.PerClient,
+		perHost:   concurrency.PerHost,
+		global:    concurrency.Global,
+	}, concurrency.Mode, concurrency.QueueTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	// 创建客户端
 	client := &http.Client{
-		Transport: transport,
+		Transport: metricsTransport{next: tracingTransport{next: transport}},
 		Timeout:   time.Duration(cfg.Proxy.TransferTimeout) * time.Second,
 	}
 
-	return &ProxyHandler{
-		client: client,
-		config: cfg,
+	handler := &ProxyHandler{
+		client:     client,
+		config:     cfg,
+		netPolicy:  netPolicy,
+		hostPolicy: hostPolicy,
+		headers:    headers,
+		caching:    caching,
+		bandwidth: newBandwidthLimiter(
+			cfg.Security.Bandwidth.PerClient,
+			cfg.Security.Bandwidth.Global,
+			cfg.Security.Bandwidth.DailyQuota),
+		conns:   conns,
+		buffers: newBufferPolicy(cfg),
 	}
+
+	// 每一跳重定向都重新执行安全检查
+	client.CheckRedirect = handler.checkRedirect
+
+	return handler, nil
 }
 
 // ServeHTTP 实现http.Handler接口
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 获取客户端IP地址
-	clientIP := getClientIP(r)
+	clientIP := utils.ClientIP(r)
 
 	// 判断是否为下载请求
 	isDownload := isDownloadRequest(r.URL.Path)
 
 	// 只记录非下载请求的基本信息，下载请求会在后续处理中记录
 	if !isDownload {
-		log.Printf("客户端: %s | 请求: %s %s",
-			clientIP,
-			r.Method,
-			r.URL.Path)
+		slog.InfoContext(r.Context(), "收到请求",
+			"client_ip", clientIP,
+			"method", r.Method,
+			"path", r.URL.Path)
 	}
 
 	// 健康检查端点保持单独处理
@@ -294,7 +236,7 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 其他非下载请求返回错误
-		http.Error(w, "无效的请求路径", http.StatusBadRequest)
+		utils.HTTPError(w, r, "无效的请求路径", http.StatusBadRequest)
 		return
 	}
 
@@ -304,140 +246,323 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 提取目标URL
+	_, validateSpan := tracing.Start(r.Context(), "url.validate", tracing.KindInternal)
 	targetURL, err := p.extractTargetURL(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("无效的URL: %v", err), http.StatusBadRequest)
-		log.Printf("客户端: %s | 错误: 无效的URL: %v",
-			clientIP,
-			err)
+		validateSpan.SetError(err)
+		validateSpan.End()
+		utils.HTTPError(w, r, fmt.Sprintf("无效的URL: %v", err), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "无效的URL",
+			"client_ip", clientIP,
+			"path", r.URL.Path,
+			"status", http.StatusBadRequest,
+			"error", err)
 		return
 	}
 
-	// 验证URL格式
-	if err := ValidateURL(targetURL); err != nil {
-		http.Error(w, fmt.Sprintf("URL验证失败: %v", err), http.StatusBadRequest)
-		log.Printf("客户端: %s | 错误: URL验证失败: %v",
-			clientIP,
-			err)
+	// 验证URL格式并检查是否为内网地址
+	validateSpan.SetAttributes("url.full", redactURL(targetURL))
+	if err := p.checkTarget(targetURL); err != nil {
+		validateSpan.SetError(err)
+		validateSpan.End()
+		utils.HTTPError(w, r, err.Error(), err.status)
+		slog.WarnContext(r.Context(), "目标地址未通过安全检查",
+			"client_ip", clientIP,
+			"target_url", targetURL.String(),
+			"status", err.status,
+			"error", err)
+		return
+	}
+	validateSpan.End()
+
+	// 检查客户端今日的流量配额
+	if exceeded, used := p.bandwidth.quotaExceeded(clientIP); exceeded {
+		limitRejections.WithLabelValues("quota").Inc()
+		utils.HTTPError(w, r, "今日流量配额已用完", StatusBandwidthLimitExceeded)
+		slog.WarnContext(r.Context(), "今日流量配额已用完",
+			"client_ip", clientIP,
+			"target_url", targetURL.String(),
+			"status", StatusBandwidthLimitExceeded,
+			"bytes", used,
+			"quota_bytes", p.config.Security.Bandwidth.DailyQuota)
 		return
 	}
 
-	// 检查是否为内网地址
-	if isPrivateIP(targetURL) {
-		http.Error(w, "不允许访问内网地址", http.StatusForbidden)
-		log.Printf("客户端: %s | 错误: 尝试访问内网地址: %s",
-			clientIP,
-			targetURL.String())
+	// 限制同时进行的传输数
+	release, err := p.conns.acquire(r.Context(), clientIP, targetURL.Hostname())
+	if err != nil {
+		var limitErr *connLimitError
+		if errors.As(err, &limitErr) {
+			limitRejections.WithLabelValues("concurrency").Inc()
+			w.Header().Set("Retry-After", "1")
+			utils.HTTPError(w, r, limitErr.Error(), StatusTooManyRequests)
+			slog.WarnContext(r.Context(), "并发连接数超限",
+				"client_ip", clientIP,
+				"target_url", targetURL.String(),
+				"status", StatusTooManyRequests,
+				"error", limitErr)
+		}
 		return
 	}
+	defer release()
+
+	// 管理接口可以通过下载跟踪器取消正在进行的传输
+	ctx, cancelTransfer := withTransferCancel(r.Context())
+	defer cancelTransfer(nil)
+	r = r.WithContext(ctx)
+
+	// 优先从磁盘缓存读取，过期的条目需要向上游重新验证
+	var staleEntry *cacheEntry
+	var staleFile *os.File
+	if p.cache != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if entry, f, ok := p.cache.Get(cacheKey(targetURL)); ok {
+			defer f.Close()
+			if entry.isFresh(p.cacheTTL(targetURL)) {
+				p.serveFromCache(w, r, targetURL, entry, f, clientIP)
+				return
+			}
+			staleEntry, staleFile = entry, f
+		}
+	}
 
 	// 设置请求超时
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(p.config.Proxy.TransferTimeout)*time.Second)
 	defer cancel()
 	r = r.WithContext(ctx)
 
+	// 合并同一URL的并发下载，转发客户端凭据时响应因人而异，不能合并
+	if p.flights != nil && staleEntry == nil && isCoalescable(r) && !p.headers.forwardsCredentials(r, targetURL.Hostname()) {
+		p.serveCoalesced(w, r, targetURL, clientIP)
+		return
+	}
+
+	p.forward(w, r, targetURL, clientIP, staleEntry, staleFile)
+}
+
+// forward 向上游发起请求并转发响应，staleEntry不为空时先对缓存进行条件验证
+func (p *ProxyHandler) forward(w http.ResponseWriter, r *http.Request, targetURL *url.URL, clientIP string, staleEntry *cacheEntry, staleFile *os.File) {
 	// 创建代理请求
 	proxyReq, err, reqCancel := p.createProxyRequest(r, targetURL)
 	if reqCancel != nil {
 		defer reqCancel()
 	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("创建代理请求失败: %v", err), http.StatusInternalServerError)
-		log.Printf("客户端: %s | 错误: 创建代理请求失败: %v",
-			clientIP,
-			err)
+		utils.HTTPError(w, r, fmt.Sprintf("创建代理请求失败: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "创建代理请求失败",
+			"client_ip", clientIP,
+			"target_url", targetURL.String(),
+			"error", err)
 		return
 	}
 
-	// 处理请求头
-	processRequestHeadersInternal(proxyReq)
-
-	// 从URL中提取文件名
-	fileName := extractFilenameFromURL(targetURL)
+	// 使用缓存条目的校验值发起条件请求
+	if staleEntry != nil {
+		setRevalidationHeaders(proxyReq, staleEntry)
+	}
 
 	// 执行代理请求
 	resp, err := p.client.Do(proxyReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("代理请求失败: %v", err), http.StatusBadGateway)
-		log.Printf("客户端: %s | 错误: 代理请求失败: %v",
-			clientIP,
-			err)
-		downloadTracker.ConnectionClosed(targetURL.String(), err)
+		if staleEntry != nil {
+			if isSecurityRejection(err) {
+				// 目标地址或重定向未通过安全检查，缓存的内容也不能再返回
+				p.cache.Remove(staleEntry.Key)
+			} else {
+				// 上游不可用时继续使用过期缓存
+				slog.WarnContext(r.Context(), "缓存验证失败，使用过期缓存", "client_ip", clientIP, "target_url", targetURL.String(), "error", err)
+				p.serveFromCache(w, r, targetURL, staleEntry, staleFile, clientIP)
+				return
+			}
+		}
+		writeUpstreamError(w, r, targetURL, clientIP, err)
 		return
 	}
+	if staleEntry != nil {
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			resp.Body.Close()
+			refreshed := p.cache.Refresh(staleEntry, resp.Header)
+			slog.InfoContext(r.Context(), "缓存验证通过", "client_ip", clientIP, "target_url", targetURL.String())
+			p.serveFromCache(w, r, targetURL, refreshed, staleFile, clientIP)
+			return
+		case resp.StatusCode < http.StatusBadRequest:
+			// 上游文件已变化，丢弃旧缓存
+			slog.InfoContext(r.Context(), "缓存已失效", "client_ip", clientIP, "target_url", targetURL.String())
+			p.cache.Remove(staleEntry.Key)
+		}
+	}
+
+	// 客户端的条件请求没有转发给上游或被上游忽略时，由代理比较校验值
+	if resp.StatusCode == http.StatusOK && notModified(r, resp.Header) {
+		resp.Body.Close()
+		p.writeNotModified(w, r, resp, targetURL)
+		slog.InfoContext(r.Context(), "客户端缓存未修改", "client_ip", clientIP, "target_url", targetURL.String(), "status", http.StatusNotModified)
+		return
+	}
+
+	// 启用断点续传，大文件启用多连接分段下载
+	p.maybeResumable(proxyReq, resp)
+	p.maybeSegment(proxyReq, resp)
 	defer resp.Body.Close()
 
+	p.streamResponse(w, r, targetURL, proxyReq, resp, clientIP)
+}
+
+// streamResponse 将上游响应头和响应体流式转发给客户端
+func (p *ProxyHandler) streamResponse(w http.ResponseWriter, r *http.Request, targetURL *url.URL, proxyReq *http.Request, resp *http.Response, clientIP string) {
+	// 从URL中提取文件名
+	fileName := extractFilenameFromURL(targetURL)
+
+	// 上游忽略了客户端的Range请求时，由代理截取所需的区间
+	if resp.StatusCode == http.StatusOK && proxyReq.Header.Get("Range") != "" {
+		if !applyLocalRange(r, resp) {
+			writeRangeNotSatisfiable(w, r, resp.ContentLength)
+			return
+		}
+	}
+
 	// 处理响应头
-	processResponseHeadersInternal(w, resp, time.Since(time.Now()))
-	// 确保文件下载头
-	ensureDownloadHeaders(w, resp, targetURL)
+	p.writeResponseHeaders(w, r, resp, targetURL, fileName)
+
+	// 判断是否需要同时写入磁盘缓存
+	var cw *cacheWriter
+	if p.cache != nil && isCacheableResponse(proxyReq, resp) {
+		var err error
+		cw, err = p.cache.NewWriter(cacheKey(targetURL), targetURL.String())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "创建缓存文件失败", "client_ip", clientIP, "target_url", targetURL.String(), "error", err)
+			cw = nil
+		}
+	}
+	if p.cache != nil {
+		w.Header().Set("X-Cache", "MISS")
+	}
 
-	// 获取并处理Content-Disposition头
-	if contentDisposition := resp.Header.Get("Content-Disposition"); contentDisposition != "" {
-		// 保留原始的Content-Disposition头
-		w.Header().Set("Content-Disposition", contentDisposition)
-	} else {
-		// 如果目标服务器没有提供Content-Disposition，尝试从URL中提取文件名
-		if fileName != "" {
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	// 创建自定义写入器，转发响应状态码时登记下载
+	writer := newTrackedWriter(w, r, targetURL.String(), fileName, clientIP, p.bandwidth)
+	writer.WriteHeader(resp.StatusCode)
+
+	// 流式传输响应体，首个客户端在传输的同时写入缓存
+	var dst io.Writer = writer
+	if cw != nil {
+		dst = io.MultiWriter(writer, cw)
+	}
+	_, span := tracing.Start(r.Context(), "body.stream", tracing.KindInternal)
+	n, err := p.buffers.copy(dst, resp.Body, resp.ContentLength)
+	_, segmented := resp.Body.(*segmentedReader)
+	span.SetAttributes("bytes", n, "segmented", segmented)
+	span.SetError(err)
+	span.End()
+
+	if cw != nil {
+		if err == nil {
+			cw.Commit(resp)
+		} else {
+			cw.Abort()
 		}
 	}
 
-	// 获取文件大小
-	fileSize := resp.ContentLength
+	// 处理下载完成或错误
+	writer.finish(err)
+}
 
-	// 转发响应状态码
-	w.WriteHeader(resp.StatusCode)
+// serveFromCache 使用磁盘缓存响应请求
+func (p *ProxyHandler) serveFromCache(w http.ResponseWriter, r *http.Request, targetURL *url.URL, entry *cacheEntry, f *os.File, clientIP string) {
+	resp := entry.response()
+	fileName := extractFilenameFromURL(targetURL)
 
-	// 使用内存池缓冲区传输响应体
-	buffer := bufferPool.Get()
-	defer bufferPool.Put(buffer)
+	p.writeResponseHeaders(w, r, resp, targetURL, fileName)
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Age", strconv.FormatInt(int64(time.Since(entry.StoredAt).Seconds()), 10))
 
-	// 获取或创建下载信息
-	downloadInfo := downloadTracker.GetOrCreate(targetURL.String(), fileName, fileSize, clientIP)
+	slog.InfoContext(r.Context(), "缓存命中", "client_ip", clientIP, "target_url", targetURL.String())
 
-	// 创建自定义写入器
-	writer := &trackedWriter{
-		w:            w,
-		downloadInfo: downloadInfo,
-	}
+	writer := newTrackedWriter(w, r, targetURL.String(), fileName, clientIP, p.bandwidth)
 
-	// 流式传输响应体
-	_, err = io.CopyBuffer(writer, resp.Body, buffer)
+	// ServeContent 负责Content-Length、Range和条件请求的处理
+	_, span := tracing.Start(r.Context(), "cache.serve", tracing.KindInternal)
+	http.ServeContent(writer, r, fileName, entry.modTime(), f)
+	span.SetError(writer.err)
+	span.End()
 
-	// 处理下载完成或错误
-	downloadTracker.ConnectionClosed(targetURL.String(), err)
+	writer.finish(writer.err)
 }
 
-// trackedWriter 是一个包装了http.ResponseWriter的结构，用于跟踪下载进度
-type trackedWriter struct {
-	w            http.ResponseWriter
-	downloadInfo *DownloadInfo
+// isSecurityRejection 判断上游请求是否因连接地址或重定向目标未通过安全检查而失败
+func isSecurityRejection(err error) bool {
+	var targetErr *targetError
+	return isBlockedAddressError(err) || errors.As(err, &targetErr)
 }
 
-// Write 实现io.Writer接口
-func (tw *trackedWriter) Write(p []byte) (int, error) {
-	n, err := tw.w.Write(p)
-	if err != nil {
-		return n, err
+// writeUpstreamError 根据上游请求的错误类型返回对应的状态码
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, targetURL *url.URL, clientIP string, err error) {
+	// 重定向目标未通过安全检查
+	var targetErr *targetError
+	if errors.As(err, &targetErr) {
+		utils.HTTPError(w, r, targetErr.Error(), targetErr.status)
+		slog.WarnContext(r.Context(), "重定向被拒绝",
+			"client_ip", clientIP,
+			"target_url", targetURL.String(),
+			"status", targetErr.status,
+			"error", targetErr)
+		return
 	}
 
-	// 更新下载进度
-	tw.downloadInfo.UpdateProgress(int64(n))
+	if isBlockedAddressError(err) {
+		utils.HTTPError(w, r, "不允许访问内网地址", http.StatusForbidden)
+		slog.WarnContext(r.Context(), "尝试访问内网地址",
+			"client_ip", clientIP,
+			"target_url", targetURL.String(),
+			"status", http.StatusForbidden,
+			"error", err)
+		return
+	}
 
-	return n, nil
+	utils.HTTPError(w, r, fmt.Sprintf("代理请求失败: %v", err), http.StatusBadGateway)
+	slog.WarnContext(r.Context(), "代理请求失败",
+		"client_ip", clientIP,
+		"target_url", targetURL.String(),
+		"status", http.StatusBadGateway,
+		"error", err)
 }
 
-// 定期清理旧的下载记录
-func init() {
-	go func() {
-		ticker := time.NewTicker(10 * time.Minute)
-		defer ticker.Stop()
+// headerVars 返回头部值模板中的变量
+func (p *ProxyHandler) headerVars(r *http.Request, targetURL *url.URL) headerVars {
+	return headerVars{
+		clientIP:  utils.ClientIP(r),
+		requestID: utils.RequestID(r.Context()),
+		host:      targetURL.Hostname(),
+	}
+}
 
-		for range ticker.C {
-			downloadTracker.CleanupOldDownloads()
-		}
-	}()
+// writeResponseHeaders 根据上游响应设置转发给客户端的响应头
+func (p *ProxyHandler) writeResponseHeaders(w http.ResponseWriter, r *http.Request, resp *http.Response, targetURL *url.URL, fileName string) {
+	// 复制上游响应头
+	p.headers.copyResponse(w.Header(), resp.Header, targetURL.Hostname())
+	w.Header().Set("X-Processing-Time", fmt.Sprintf("%.6fs", time.Since(time.Now()).Seconds()))
+	// 确保文件下载头，304响应不包含响应体
+	if resp.StatusCode != http.StatusNotModified {
+		ensureDownloadHeaders(w, resp, targetURL)
+	}
+	// 只有返回文件内容时才设置Content-Disposition头
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		setContentDisposition(w, resp, fileName)
+	}
+	// 按缓存头模式和规则设置Cache-Control
+	p.caching.apply(w.Header(), resp.StatusCode, targetURL)
+	// 最后应用头部策略，规则可以覆盖以上所有响应头
+	p.headers.applyResponse(w.Header(), p.headerVars(r, targetURL))
+}
+
+// setContentDisposition 设置Content-Disposition头
+func setContentDisposition(w http.ResponseWriter, resp *http.Response, fileName string) {
+	if contentDisposition := resp.Header.Get("Content-Disposition"); contentDisposition != "" {
+		// 保留原始的Content-Disposition头
+		w.Header().Set("Content-Disposition", contentDisposition)
+	} else if fileName != "" {
+		// 如果目标服务器没有提供Content-Disposition，使用从URL中提取的文件名
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	}
 }
 
 // isClientDisconnectError 检查错误是否为客户端断开连接
@@ -523,38 +648,15 @@ func (p *ProxyHandler) createProxyRequest(r *http.Request, targetURL *url.URL) (
 		proxyReq.Header.Set("X-Forwarded-For", clientIP)
 	}
 
-	return proxyReq, nil, cancel
-}
-
-// isPrivateIP 检查URL是否指向私有IP地址
-func isPrivateIP(targetURL *url.URL) bool {
-	host := targetURL.Hostname()
-
-	// 如果是IP地址，直接检查
-	if ip := net.ParseIP(host); ip != nil {
-		return ip.IsLoopback() || ip.IsPrivate()
-	}
-
-	// 解析域名为IP
-	addrs, err := net.LookupHost(host)
-	if err != nil {
-		return false
+	// 将请求ID传给上游，便于关联两端的日志
+	if id := utils.RequestID(r.Context()); id != "" {
+		proxyReq.Header.Set("X-Request-ID", id)
 	}
 
-	// 检查解析出的所有IP
-	for _, addr := range addrs {
-		ip := net.ParseIP(addr)
-		if ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
-			return true
-		}
-
-		// 使用正则表达式检查是否为RFC1918地址
-		if privateIPRegex.MatchString(addr) {
-			return true
-		}
-	}
+	// 按头部策略删除、放行和修改请求头
+	p.headers.applyRequest(proxyReq, p.headerVars(r, targetURL))
 
-	return false
+	return proxyReq, nil, cancel
 }
 
 // 确保正确设置文件下载头
@@ -587,11 +689,6 @@ func ensureDownloadHeaders(w http.ResponseWriter, resp *http.Response, targetURL
 	if contentLength != "" {
 		w.Header().Set("Content-Length", contentLength)
 	}
-
-	// 添加额外头，防止浏览器缓存
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
 }
 
 // 添加此辅助函数用于从URL中提取文件名
@@ -629,123 +726,12 @@ func extractFilenameFromURL(targetURL *url.URL) string {
 	return ""
 }
 
-// formatFileSize 根据文件大小自动选择合适的单位
-func formatFileSize(size int64) string {
-	if size < 0 {
-		return "未知大小"
-	}
-
-	const (
-		B  = 1
-		KB = 1024 * B
-		MB = 1024 * KB
-		GB = 1024 * MB
-		TB = 1024 * GB
-	)
-
-	switch {
-	case size >= TB:
-		return fmt.Sprintf("%.2f TB", float64(size)/TB)
-	case size >= GB:
-		return fmt.Sprintf("%.2f GB", float64(size)/GB)
-	case size >= MB:
-		return fmt.Sprintf("%.2f MB", float64(size)/MB)
-	case size >= KB:
-		return fmt.Sprintf("%.2f KB", float64(size)/KB)
-	default:
-		return fmt.Sprintf("%d B", size)
-	}
-}
-
-// getClientIP 获取客户端真实IP地址
-func getClientIP(r *http.Request) string {
-	// 首先尝试从X-Forwarded-For头获取
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		// 取第一个IP（最原始的客户端IP）
-		if len(ips) > 0 {
-			clientIP := strings.TrimSpace(ips[0])
-			if clientIP != "" {
-				return clientIP
-			}
-		}
-	}
-
-	// 尝试从X-Real-IP头获取
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return strings.TrimSpace(xrip)
-	}
-
-	// 从RemoteAddr获取
-	if r.RemoteAddr != "" {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err == nil {
-			return ip
-		}
-		return r.RemoteAddr
-	}
-
-	return "未知IP"
-}
-
 // isDownloadRequest 判断是否为下载请求
 func isDownloadRequest(path string) bool {
 	return strings.HasPrefix(path, "/http:/") ||
 		strings.HasPrefix(path, "/https:/")
 }
 
-// 重命名这些函数以避免冲突
-func processRequestHeadersInternal(r *http.Request) {
-	// 删除Proxy-*系列头
-	for header := range r.Header {
-		if strings.HasPrefix(strings.ToLower(header), "proxy-") {
-			r.Header.Del(header)
-		}
-	}
-
-	// 重写Via头
-	if via := r.Header.Get("Via"); via != "" {
-		r.Header.Set("Via", via+", "+proxyIdentifier)
-	} else {
-		r.Header.Set("Via", r.Proto+" "+proxyIdentifier)
-	}
-
-	// 清理敏感头
-	for _, header := range sensitiveHeaders {
-		r.Header.Del(header)
-	}
-}
-
-// 重命名这些函数以避免冲突
-func processResponseHeadersInternal(w http.ResponseWriter, resp *http.Response, duration time.Duration) {
-	// 复制所有响应头
-	for header, values := range resp.Header {
-		// 跳过敏感头
-		if containsInternal(sensitiveHeaders, header) {
-			continue
-		}
-
-		for _, value := range values {
-			w.Header().Add(header, value)
-		}
-	}
-
-	// 添加安全头
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
-	w.Header().Set("X-Frame-Options", "DENY")
-
-	// 如果是HTTPS请求，添加HSTS头
-	w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-
-	// 添加CSP头
-	w.Header().Set("Content-Security-Policy", "default-src 'self'")
-
-	// 添加处理时间
-	w.Header().Set("X-Processing-Time", fmt.Sprintf("%.6fs", duration.Seconds()))
-	w.Header().Set("X-Proxy-Node", nodeID)
-}
-
 // 重命名这个函数以避免冲突
 func containsInternal(slice []string, item string) bool {
 	item = strings.ToLower(item)
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+// sensitiveHeaders 启用removeSensitiveHeaders时删除的敏感头
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// credentialHeaders 携带客户端凭据的请求头，转发这些头时响应不能共享给其他客户端
+var credentialHeaders = []string{
+	"Authorization",
+	"Cookie",
+}
+
+// headerPolicy 按配置处理转发给上游的请求头和返回给客户端的响应头
+// 先按removeProxyHeaders和removeSensitiveHeaders删除头，再依次应用全局修改和匹配目标主机的规则
+type headerPolicy struct {
+	removeProxy     bool
+	removeSensitive bool
+	nodeID          string
+
+	request  headerActions
+	response headerActions
+	rules    []headerRule
+}
+
+// headerRule 编译后的按主机生效的头部规则
+type headerRule struct {
+	host     hostRule
+	request  headerActions
+	response headerActions
+}
+
+// headerActions 编译后的头部修改，头名称已规范化，设置和追加按名称排序
+type headerActions struct {
+	set    []headerValue
+	add    []headerValue
+	remove []string
+	pass   []string
+}
+
+// headerValue 头名称和值模板
+type headerValue struct {
+	name  string
+	value string
+}
+
+// headerVars 头部值模板中的变量
+type headerVars struct {
+	clientIP  string
+	requestID string
+	host      string
+}
+
+// newHeaderPolicy 根据配置创建头部策略
+func newHeaderPolicy(cfg *config.Config) (*headerPolicy, error) {
+	hp := &headerPolicy{
+		removeProxy:     cfg.Headers.RemoveProxyHeaders,
+		removeSensitive: cfg.Headers.RemoveSensitiveHeaders,
+		nodeID:          cfg.Headers.NodeID,
+		request:         compileHeaderActions(cfg.Headers.Request),
+		response:        compileHeaderActions(cfg.Headers.Response),
+	}
+
+	for _, rule := range cfg.Headers.Rules {
+		hosts, err := compileHostRules([]config.HostRule{{Name: rule.Name, Pattern: rule.Pattern}})
+		if err != nil {
+			return nil, err
+		}
+		hp.rules = append(hp.rules, headerRule{
+			host:     hosts[0],
+			request:  compileHeaderActions(rule.Request),
+			response: compileHeaderActions(rule.Response),
+		})
+	}
+	return hp, nil
+}
+
+// compileHeaderActions 规范化头名称
+func compileHeaderActions(actions config.HeaderActions) headerActions {
+	values := func(m map[string]string) []headerValue {
+		list := make([]headerValue, 0, len(m))
+		for name, value := range m {
+			list = append(list, headerValue{name: http.CanonicalHeaderKey(name), value: value})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+		return list
+	}
+	names := func(list []string) []string {
+		result := make([]string, len(list))
+		for i, name := range list {
+			result[i] = http.CanonicalHeaderKey(name)
+		}
+		return result
+	}
+	return headerActions{
+		set:    values(actions.Set),
+		add:    values(actions.Add),
+		remove: names(actions.Remove),
+		pass:   names(actions.Pass),
+	}
+}
+
+// apply 依次删除、设置和追加头
+func (a headerActions) apply(h http.Header, expand func(string) string) {
+	for _, name := range a.remove {
+		h.Del(name)
+	}
+	for _, hv := range a.set {
+		if hv.value == "" {
+			h.Del(hv.name)
+		} else {
+			h.Set(hv.name, expand(hv.value))
+		}
+	}
+	for _, hv := range a.add {
+		h.Add(hv.name, expand(hv.value))
+	}
+}
+
+// matching 返回全局修改和匹配目标主机的规则中的修改，request为false时返回响应头的修改
+func (hp *headerPolicy) matching(host string, request bool) []headerActions {
+	host = normalizeHost(host)
+	list := []headerActions{hp.response}
+	if request {
+		list[0] = hp.request
+	}
+	for _, rule := range hp.rules {
+		if !rule.host.match(host) {
+			continue
+		}
+		if request {
+			list = append(list, rule.request)
+		} else {
+			list = append(list, rule.response)
+		}
+	}
+	return list
+}
+
+// stripped 判断头是否会被removeProxyHeaders或removeSensitiveHeaders删除
+func (hp *headerPolicy) stripped(name string, actions []headerActions) bool {
+	name = http.CanonicalHeaderKey(name)
+	strip := hp.removeProxy && strings.HasPrefix(name, "Proxy-") ||
+		hp.removeSensitive && containsInternal(sensitiveHeaders, name)
+	if !strip {
+		return false
+	}
+	for _, a := range actions {
+		if containsInternal(a.pass, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// expander 返回替换模板变量的函数
+func (hp *headerPolicy) expander(vars headerVars) func(string) string {
+	replacer := strings.NewReplacer(
+		"{client_ip}", vars.clientIP,
+		"{request_id}", vars.requestID,
+		"{node_id}", hp.nodeID,
+		"{host}", vars.host)
+	return func(value string) string {
+		if !strings.Contains(value, "{") {
+			return value
+		}
+		return replacer.Replace(value)
+	}
+}
+
+// applyRequest 处理转发给上游的请求头
+func (hp *headerPolicy) applyRequest(req *http.Request, vars headerVars) {
+	actions := hp.matching(vars.host, true)
+	for name := range req.Header {
+		if hp.stripped(name, actions) {
+			req.Header.Del(name)
+		}
+	}
+
+	// 重写Via头
+	if via := req.Header.Get("Via"); via != "" {
+		req.Header.Set("Via", via+", "+proxyIdentifier)
+	} else {
+		req.Header.Set("Via", req.Proto+" "+proxyIdentifier)
+	}
+
+	expand := hp.expander(vars)
+	for _, a := range actions {
+		a.apply(req.Header, expand)
+	}
+}
+
+// copyResponse 将上游响应头复制给客户端，跳过需要删除的头
+// 上游的X-Request-ID不复制，客户端收到的始终是RequestID中间件分配的ID
+func (hp *headerPolicy) copyResponse(dst, src http.Header, host string) {
+	actions := hp.matching(host, false)
+	for name, values := range src {
+		if hp.stripped(name, actions) || http.CanonicalHeaderKey(name) == "X-Request-Id" {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+	if hp.nodeID != "" {
+		dst.Set("X-Proxy-Node", hp.nodeID)
+	}
+}
+
+// applyResponse 对返回给客户端的响应头应用全局修改和规则，在其他响应头都设置完成后调用
+func (hp *headerPolicy) applyResponse(h http.Header, vars headerVars) {
+	expand := hp.expander(vars)
+	for _, a := range hp.matching(vars.host, false) {
+		a.apply(h, expand)
+	}
+}
+
+// forwardsCredentials 判断客户端的凭据头是否会转发给上游
+// 此时上游的响应因客户端而异，不能缓存，也不能合并给其他客户端
+func (hp *headerPolicy) forwardsCredentials(r *http.Request, host string) bool {
+	actions := hp.matching(host, true)
+	for _, name := range credentialHeaders {
+		if r.Header.Get(name) == "" || hp.stripped(name, actions) {
+			continue
+		}
+		removed := false
+		for _, a := range actions {
+			if containsInternal(a.remove, name) {
+				removed = true
+			}
+			for _, hv := range a.set {
+				if hv.name == name {
+					removed = true
+				}
+			}
+		}
+		if !removed {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect 重定向到其他主机时，删除只对原主机生效的规则设置、追加或放行的请求头，
+// 避免内部主机的凭据随重定向发送给第三方
+func (hp *headerPolicy) redirect(h http.Header, fromHost, toHost string) {
+	fromHost, toHost = normalizeHost(fromHost), normalizeHost(toHost)
+	if fromHost == toHost {
+		return
+	}
+	for _, rule := range hp.rules {
+		if !rule.host.match(fromHost) || rule.host.match(toHost) {
+			continue
+		}
+		for _, hv := range rule.request.set {
+			h.Del(hv.name)
+		}
+		for _, hv := range rule.request.add {
+			h.Del(hv.name)
+		}
+		for _, name := range rule.request.pass {
+			h.Del(name)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/proxy-service/config"
+	"github.com/yourusername/proxy-service/middleware"
+	"github.com/yourusername/proxy-service/utils"
+)
+
+func TestUpstreamRequestIDNotDuplicated(t *testing.T) {
+	// 上游原样返回收到的X-Request-ID，并附带一个自己的ID
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Request-ID", r.Header.Get("X-Request-ID"))
+		w.Header().Add("X-Request-ID", "upstream-generated")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Security.PrivateIPBlocking = false
+	handler, err := newProxyHandler(cfg)
+	if err != nil {
+		t.Fatalf("创建处理器失败: %v", err)
+	}
+	resolver, err := utils.NewClientIPResolver(nil)
+	if err != nil {
+		t.Fatalf("创建客户端IP解析器失败: %v", err)
+	}
+	server := httptest.NewServer(middleware.RequestID(resolver, handler))
+	defer server.Close()
+
+	target := strings.Replace(upstream.URL, "http://", "http:/", 1)
+	resp, err := http.Get(server.URL + "/" + target + "/file.txt")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("状态码为%d，应为200", resp.StatusCode)
+	}
+	ids := resp.Header.Values("X-Request-ID")
+	if len(ids) != 1 || ids[0] == "upstream-generated" {
+		t.Errorf("响应的X-Request-ID为%q，应只包含代理分配的ID", ids)
+	}
+}
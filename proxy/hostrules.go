@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+// hostPolicy 根据配置的规则决定是否允许访问目标主机
+// 先匹配禁止规则，再匹配允许规则；允许列表为空时不限制目标主机
+type hostPolicy struct {
+	allow []hostRule
+	deny  []hostRule
+}
+
+// hostRule 编译后的主机规则
+type hostRule struct {
+	name    string
+	pattern string
+	re      *regexp.Regexp // 以"regex:"开头的规则
+}
+
+// newHostPolicy 编译配置中的主机规则
+func newHostPolicy(allow, deny []config.HostRule) (*hostPolicy, error) {
+	hp := &hostPolicy{}
+
+	var err error
+	if hp.allow, err = compileHostRules(allow); err != nil {
+		return nil, err
+	}
+	if hp.deny, err = compileHostRules(deny); err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
+
+// compileHostRules 编译规则列表，未命名的规则使用其匹配模式作为名称
+func compileHostRules(rules []config.HostRule) ([]hostRule, error) {
+	compiled := make([]hostRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("主机规则 %q 缺少pattern", rule.Name)
+		}
+
+		hr := hostRule{name: rule.Name, pattern: strings.ToLower(rule.Pattern)}
+		if hr.name == "" {
+			hr.name = rule.Pattern
+		}
+		if expr, ok := strings.CutPrefix(rule.Pattern, "regex:"); ok {
+			// 正则表达式需匹配完整的主机名，避免"github\.com"匹配到"github.com.evil.example"
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("主机规则 %q 的正则表达式无效: %v", hr.name, err)
+			}
+			hr.re = re
+		} else if _, err := path.Match(hr.pattern, ""); err != nil {
+			return nil, fmt.Errorf("主机规则 %q 的通配符无效: %v", hr.name, err)
+		}
+		compiled = append(compiled, hr)
+	}
+	return compiled, nil
+}
+
+// match 判断主机名是否匹配规则
+func (hr hostRule) match(host string) bool {
+	if hr.re != nil {
+		return hr.re.MatchString(host)
+	}
+	return matchHostPattern(host, hr.pattern)
+}
+
+// check 检查目标主机，拒绝时返回包含规则名称的错误
+func (hp *hostPolicy) check(host string) *targetError {
+	host = normalizeHost(host)
+
+	for _, rule := range hp.deny {
+		if rule.match(host) {
+			return &targetError{
+				status: http.StatusForbidden,
+				msg:    fmt.Sprintf("目标主机 %s 命中禁止规则: %s", host, rule.name),
+			}
+		}
+	}
+
+	if len(hp.allow) == 0 {
+		return nil
+	}
+	for _, rule := range hp.allow {
+		if rule.match(host) {
+			return nil
+		}
+	}
+	return &targetError{
+		status: http.StatusForbidden,
+		msg:    fmt.Sprintf("目标主机 %s 不在允许列表中", host),
+	}
+}
+
+// normalizeHost 将主机名转为小写并去掉末尾的"."
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// matchHostPattern 判断主机名是否匹配规则
+// 以"."开头的规则匹配该域名本身及其所有子域名；
+// 包含"*"的规则按通配符匹配，"*"不跨越"."，如"*.example.com"只匹配一级子域名；
+// 其余规则要求完全相同
+func matchHostPattern(host, pattern string) bool {
+	host = normalizeHost(host)
+	pattern = strings.ToLower(pattern)
+
+	if strings.HasPrefix(pattern, ".") {
+		return host == pattern[1:] || strings.HasSuffix(host, pattern)
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		// 主机名中不含"/"，这里借用path.Match使"*"不跨越"."
+		matched, _ := path.Match(strings.ReplaceAll(pattern, ".", "/"), strings.ReplaceAll(host, ".", "/"))
+		return matched
+	}
+	return host == pattern
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+func TestHostRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"files.example.com", "files.example.com", true},
+		{"files.example.com", "FILES.example.com.", true},
+		{"files.example.com", "a.files.example.com", false},
+		{".example.com", "example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "badexample.com", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "a.b.example.com", false},
+		{`regex:github\.com`, "github.com", true},
+		{`regex:github\.com`, "github.com.evil.example", false},
+		{`regex:github\.com`, "evilgithub.com", false},
+		{`regex:pypi\.org|files\.pythonhosted\.org`, "files.pythonhosted.org", true},
+		{`regex:pypi\.org|files\.pythonhosted\.org`, "pypi.org.evil.example", false},
+		{`regex:^cdn[0-9]+\.example\.com$`, "cdn12.example.com", true},
+	}
+	for _, tt := range tests {
+		rules, err := compileHostRules([]config.HostRule{{Pattern: tt.pattern}})
+		if err != nil {
+			t.Fatalf("编译规则 %q 失败: %v", tt.pattern, err)
+		}
+		if got := rules[0].match(normalizeHost(tt.host)); got != tt.want {
+			t.Errorf("规则 %q 匹配 %q 的结果为%v，应为%v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
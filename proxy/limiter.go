@@ -1,10 +1,14 @@
 package proxy
 
 import (
-	"net"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/yourusername/proxy-service/utils"
 )
 
 // 自定义HTTP错误码
@@ -13,107 +17,287 @@ const (
 	StatusBandwidthLimitExceeded = 509 // 非标准，但常用
 )
 
-// RateLimiter 使用滑动窗口限制请求频率
-type RateLimiter struct {
+// 支持的限流算法
+const (
+	AlgorithmSlidingWindow = "slidingWindow"
+	AlgorithmTokenBucket   = "tokenBucket"
+	AlgorithmGCRA          = "gcra"
+)
+
+// limiterIdleTimeout 闲置超过该时间的客户端状态会被清理
+const limiterIdleTimeout = 10 * time.Minute
+
+// Limiter 按客户端限制请求频率
+type Limiter interface {
+	// Allow 判断是否允许请求，拒绝时返回建议的重试等待时间
+	Allow(key string) (bool, time.Duration)
+}
+
+// NewLimiter 根据算法名称创建频率限制器
+// burst为允许的突发请求数，滑动窗口算法不使用该参数
+func NewLimiter(algorithm string, requestsPerMinute int, burst int) (Limiter, error) {
+	if requestsPerMinute <= 0 {
+		return nil, fmt.Errorf("requestsPerMinute必须大于0")
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	switch algorithm {
+	case "", AlgorithmSlidingWindow:
+		return NewSlidingWindowLimiter(requestsPerMinute), nil
+	case AlgorithmTokenBucket:
+		return NewTokenBucketLimiter(requestsPerMinute, burst), nil
+	case AlgorithmGCRA:
+		return NewGCRALimiter(requestsPerMinute, burst), nil
+	default:
+		return nil, fmt.Errorf("不支持的限流算法: %s", algorithm)
+	}
+}
+
+// SlidingWindowLimiter 使用滑动窗口限制请求频率
+// 每个客户端保存窗口内请求时间戳的环形缓冲区
+type SlidingWindowLimiter struct {
 	windows     map[string]*slidingWindow
 	windowSize  time.Duration
 	maxRequests int
-	mu          sync.RWMutex
+	lastSweep   time.Time
+	mu          sync.Mutex
 }
 
 // slidingWindow 记录时间窗口内的请求
 type slidingWindow struct {
 	timestamps []time.Time
-	index      int
-	size       int
-	lastClean  time.Time
+	head       int // 最早一条记录的位置
+	count      int // 窗口内的记录数
 }
 
-// NewRateLimiter 创建一个新的频率限制器
-// 默认每IP每分钟允许60个请求
-func NewRateLimiter(requestsPerMinute int) *RateLimiter {
-	return &RateLimiter{
+// NewSlidingWindowLimiter 创建滑动窗口频率限制器
+func NewSlidingWindowLimiter(requestsPerMinute int) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
 		windows:     make(map[string]*slidingWindow),
 		windowSize:  time.Minute,
 		maxRequests: requestsPerMinute,
+		lastSweep:   time.Now(),
 	}
 }
 
 // Allow 判断是否允许请求
-func (rl *RateLimiter) Allow(ip string) bool {
+func (rl *SlidingWindowLimiter) Allow(key string) (bool, time.Duration) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
+	rl.sweep(now)
 
-	// 获取该IP的窗口，如果不存在则创建
-	window, exists := rl.windows[ip]
+	// 获取该客户端的窗口，如果不存在则创建
+	window, exists := rl.windows[key]
 	if !exists {
 		window = &slidingWindow{
 			timestamps: make([]time.Time, rl.maxRequests),
-			size:       rl.maxRequests,
-			lastClean:  now,
 		}
-		rl.windows[ip] = window
+		rl.windows[key] = window
 	}
 
 	// 清理过期记录
-	if now.Sub(window.lastClean) > time.Minute {
-		rl.cleanupOldRecords(window, now)
+	window.expire(now.Add(-rl.windowSize))
+
+	// 窗口内的请求数已达上限，等待最早的记录过期
+	if window.count >= rl.maxRequests {
+		return false, window.timestamps[window.head].Add(rl.windowSize).Sub(now)
 	}
 
-	// 获取窗口最老的时间戳
-	oldestTime := window.timestamps[(window.index+1)%window.size]
+	// 记录当前请求时间戳
+	window.timestamps[(window.head+window.count)%len(window.timestamps)] = now
+	window.count++
+	return true, 0
+}
 
-	// 如果最老的记录在窗口期内且总数已达上限，则拒绝请求
-	if !oldestTime.IsZero() && now.Sub(oldestTime) <= rl.windowSize && countNonZero(window.timestamps) >= window.size {
-		return false
+// expire 从头部移除早于threshold的记录
+func (sw *slidingWindow) expire(threshold time.Time) {
+	for sw.count > 0 && !sw.timestamps[sw.head].After(threshold) {
+		sw.head = (sw.head + 1) % len(sw.timestamps)
+		sw.count--
 	}
+}
 
-	// 记录当前请求时间戳
-	window.timestamps[window.index] = now
-	window.index = (window.index + 1) % window.size
+// sweep 定期删除窗口已清空的客户端，调用方需持有锁
+func (rl *SlidingWindowLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	rl.lastSweep = now
+
+	threshold := now.Add(-rl.windowSize)
+	for key, window := range rl.windows {
+		window.expire(threshold)
+		if window.count == 0 {
+			delete(rl.windows, key)
+		}
+	}
+}
+
+// TokenBucketLimiter 使用令牌桶限制请求频率
+// 令牌按固定速率补充，桶的容量决定允许的突发请求数
+type TokenBucketLimiter struct {
+	buckets   map[string]*tokenBucket
+	rate      float64 // 每秒补充的令牌数
+	capacity  float64
+	lastSweep time.Time
+	mu        sync.Mutex
+}
 
-	return true
+// tokenBucket 单个客户端的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
 }
 
-// 清理过期记录
-func (rl *RateLimiter) cleanupOldRecords(window *slidingWindow, now time.Time) {
-	// 使用环形缓冲区，只清理头部过期的记录
-	for !window.timestamps[window.index].IsZero() && window.timestamps[window.index].Before(now.Add(-rl.windowSize)) {
-		window.timestamps[window.index] = time.Time{}
-		window.index = (window.index + 1) % window.size
+// NewTokenBucketLimiter 创建令牌桶频率限制器
+func NewTokenBucketLimiter(requestsPerMinute int, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		rate:      float64(requestsPerMinute) / 60,
+		capacity:  float64(burst),
+		lastSweep: time.Now(),
 	}
-	window.lastClean = now
 }
 
-// 计算非零时间戳数量
-func countNonZero(timestamps []time.Time) int {
-	count := 0
-	for _, t := range timestamps {
-		if !t.IsZero() {
-			count++
+// Allow 判断是否允许请求
+func (tb *TokenBucketLimiter) Allow(key string) (bool, time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.sweep(now)
+
+	bucket, exists := tb.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: tb.capacity, last: now}
+		tb.buckets[key] = bucket
+	}
+
+	// 按经过的时间补充令牌
+	bucket.tokens += now.Sub(bucket.last).Seconds() * tb.rate
+	if bucket.tokens > tb.capacity {
+		bucket.tokens = tb.capacity
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / tb.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep 定期删除令牌已补满的客户端，调用方需持有锁
+func (tb *TokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(tb.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	tb.lastSweep = now
+
+	for key, bucket := range tb.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*tb.rate >= tb.capacity {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// GCRALimiter 使用通用信元速率算法（GCRA）限制请求频率
+// 每个客户端只需记录理论到达时间，效果与令牌桶相同但状态更小
+type GCRALimiter struct {
+	tats      map[string]time.Time // 理论到达时间
+	interval  time.Duration        // 两个请求之间的标准间隔
+	tolerance time.Duration        // 允许提前到达的时间，决定突发请求数
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewGCRALimiter 创建GCRA频率限制器
+func NewGCRALimiter(requestsPerMinute int, burst int) *GCRALimiter {
+	interval := time.Minute / time.Duration(requestsPerMinute)
+	return &GCRALimiter{
+		tats:      make(map[string]time.Time),
+		interval:  interval,
+		tolerance: interval * time.Duration(burst-1),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow 判断是否允许请求
+func (g *GCRALimiter) Allow(key string) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.sweep(now)
+
+	tat := g.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+
+	// 理论到达时间超前太多说明请求过快
+	if ahead := tat.Sub(now); ahead > g.tolerance {
+		return false, ahead - g.tolerance
+	}
+
+	g.tats[key] = tat.Add(g.interval)
+	return true, 0
+}
+
+// sweep 定期删除理论到达时间已过去的客户端，调用方需持有锁
+func (g *GCRALimiter) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	g.lastSweep = now
+
+	for key, tat := range g.tats {
+		if tat.Before(now) {
+			delete(g.tats, key)
 		}
 	}
-	return count
 }
 
 // LimitRate 是一个中间件，用于在请求级别限制频率
-func LimitRate(limiter *RateLimiter, next http.Handler) http.Handler {
+// exemptPaths中以"/"结尾的路径按前缀匹配，其余路径要求完全相同
+func LimitRate(limiter Limiter, exemptPaths []string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 提取客户端IP
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			ip = r.RemoteAddr
+		if isExemptPath(r.URL.Path, exemptPaths) {
+			next.ServeHTTP(w, r)
+			return
 		}
 
 		// 检查是否允许请求
-		if !limiter.Allow(ip) {
-			w.Header().Set("Retry-After", "60")
-			http.Error(w, "请求频率超限", StatusTooManyRequests)
+		if allowed, retryAfter := limiter.Allow(utils.ClientIP(r)); !allowed {
+			limitRejections.WithLabelValues("rate").Inc()
+			seconds := int((retryAfter + time.Second - 1) / time.Second)
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			utils.HTTPError(w, r, "请求频率超限", StatusTooManyRequests)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// isExemptPath 判断请求路径是否免于限流
+func isExemptPath(path string, exemptPaths []string) bool {
+	for _, exempt := range exemptPaths {
+		if strings.HasSuffix(exempt, "/") {
+			if strings.HasPrefix(path, exempt) {
+				return true
+			}
+		} else if path == exempt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/proxy-service/metrics"
+)
+
+var (
+	// 上游请求耗时，从发出请求到收到响应头
+	upstreamDuration = metrics.NewHistogramVec(
+		"dl_proxy_upstream_request_duration_seconds",
+		"上游请求从发出到收到响应头的耗时",
+		metrics.DefBuckets,
+		"host")
+
+	// 上游错误数，reason为network、blocked或5xx状态码
+	upstreamErrors = metrics.NewCounterVec(
+		"dl_proxy_upstream_errors_total",
+		"按上游主机统计的错误数",
+		"host", "reason")
+
+	// 各类限制拒绝的请求数
+	limitRejections = metrics.NewCounterVec(
+		"dl_proxy_limit_rejections_total",
+		"被频率、并发或流量配额限制拒绝的请求数",
+		"reason")
+)
+
+func init() {
+	metrics.NewGaugeFunc(
+		"dl_proxy_active_downloads",
+		"仍有活跃连接的下载数",
+		func() float64 { return float64(downloadTracker.ActiveDownloads()) })
+	metrics.NewGaugeFunc(
+		"dl_proxy_active_transfers",
+		"正在进行的代理传输数",
+		func() float64 { return float64(downloadTracker.ActiveConnections().Total) })
+
+	metrics.NewCounterFunc(
+		"dl_proxy_buffer_pool_gets_total",
+		"从缓冲区池获取缓冲区的次数",
+		func() float64 { return float64(bufferPool.Stats().Gets) })
+	metrics.NewCounterFunc(
+		"dl_proxy_buffer_pool_allocs_total",
+		"缓冲区池新分配缓冲区的次数",
+		func() float64 { return float64(bufferPool.Stats().Allocs) })
+	metrics.NewGaugeFunc(
+		"dl_proxy_buffer_pool_in_use",
+		"已取出尚未归还的缓冲区数",
+		func() float64 {
+			stats := bufferPool.Stats()
+			return float64(stats.Gets - stats.Puts)
+		})
+}
+
+// metricsTransport 记录每个上游请求的耗时和错误
+// 包装在最外层，重定向、分段和续传请求都会被统计
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip 实现http.RoundTripper接口
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	host := normalizeHost(req.URL.Hostname())
+	upstreamDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+	switch {
+	case err != nil && isBlockedAddressError(err):
+		upstreamErrors.WithLabelValues(host, "blocked").Inc()
+	case err != nil:
+		upstreamErrors.WithLabelValues(host, "network").Inc()
+	case resp.StatusCode >= http.StatusInternalServerError:
+		upstreamErrors.WithLabelValues(host, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
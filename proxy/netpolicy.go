@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// 开启privateIPBlocking时默认禁止连接的地址段
+var defaultBlockedCIDRs = []string{
+	"0.0.0.0/8",          // 本网络
+	"10.0.0.0/8",         // RFC1918
+	"100.64.0.0/10",      // 运营商级NAT
+	"127.0.0.0/8",        // 回环地址
+	"169.254.0.0/16",     // 链路本地，包含云厂商元数据服务
+	"172.16.0.0/12",      // RFC1918
+	"192.0.0.0/24",       // IETF协议分配
+	"192.168.0.0/16",     // RFC1918
+	"198.18.0.0/15",      // 基准测试
+	"224.0.0.0/4",        // 组播
+	"240.0.0.0/4",        // 保留地址及广播
+	"::/128",             // 未指定地址
+	"::1/128",            // 回环地址
+	"fc00::/7",           // 唯一本地地址
+	"fe80::/10",          // 链路本地
+	"ff00::/8",           // 组播
+	"100::/64",           // 丢弃前缀
+	"2001:db8::/32",      // 文档地址
+	"fec0::/10",          // 已废弃的站点本地地址
+	"::ffff:0:0:0/96",    // IPv4转换地址
+	"64:ff9b:1::/48",     // 本地NAT64
+	"2002::/16",          // 6to4，可能内嵌任意IPv4地址
+	"2001::/32",          // Teredo，可能内嵌任意IPv4地址
+	"::/96",              // 已废弃的IPv4兼容地址
+	"255.255.255.255/32", // 广播
+}
+
+// nat64Prefix 公共NAT64前缀，地址的最后32位是内嵌的IPv4地址
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// networkPolicy 在建立连接时校验目标地址
+// 校验发生在DNS解析之后的拨号阶段，因此DNS重绑定和重定向都无法绕过
+type networkPolicy struct {
+	deny  []netip.Prefix
+	allow []netip.Prefix
+}
+
+// enabled 判断是否需要校验连接地址
+func (np *networkPolicy) enabled() bool {
+	return len(np.deny) > 0
+}
+
+// envProxyConfigured 判断是否通过环境变量设置了HTTP代理
+func envProxyConfigured() bool {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedAddressError 表示目标地址被安全策略拒绝
+type blockedAddressError struct {
+	addr netip.Addr
+}
+
+func (e *blockedAddressError) Error() string {
+	return fmt.Sprintf("不允许访问内网地址: %s", e.addr)
+}
+
+// newNetworkPolicy 根据配置创建地址校验策略
+// allowCIDRs中的地址段优先于禁止列表，可用于放行内部的制品服务器
+func newNetworkPolicy(blockPrivate bool, denyCIDRs, allowCIDRs []string) (*networkPolicy, error) {
+	np := &networkPolicy{}
+
+	var deny []string
+	if blockPrivate {
+		deny = append(deny, defaultBlockedCIDRs...)
+	}
+	deny = append(deny, denyCIDRs...)
+
+	var err error
+	if np.deny, err = utils.ParsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	if np.allow, err = utils.ParsePrefixes(allowCIDRs); err != nil {
+		return nil, err
+	}
+	return np, nil
+}
+
+// blocked 判断地址是否禁止连接
+func (np *networkPolicy) blocked(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+
+	if containsAddr(np.allow, addr) {
+		return false
+	}
+	if containsAddr(np.deny, addr) {
+		return true
+	}
+
+	// 检查NAT64地址内嵌的IPv4地址
+	if nat64Prefix.Contains(addr) {
+		raw := addr.As16()
+		return np.blocked(netip.AddrFrom4([4]byte{raw[12], raw[13], raw[14], raw[15]}))
+	}
+	return false
+}
+
+// blockedHost 检查URL中直接写明的IP地址，域名由拨号阶段校验
+func (np *networkPolicy) blockedHost(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return np.blocked(addr)
+}
+
+// control 作为net.Dialer的Control钩子，在连接建立前校验实际连接的地址
+func (np *networkPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if np.blocked(addr) {
+		return &blockedAddressError{addr: addr}
+	}
+	return nil
+}
+
+// containsAddr 判断地址是否在任一地址段内
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedAddressError 判断错误是否由地址校验引起
+func isBlockedAddressError(err error) bool {
+	var blockedErr *blockedAddressError
+	return errors.As(err, &blockedErr)
+}
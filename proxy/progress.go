@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+const (
+	// ProgressPath 下载进度推送接口的路径
+	ProgressPath = "/api/progress"
+
+	progressInterval    = time.Second      // 推送进度的间隔
+	progressWaitTimeout = 60 * time.Second // 等待下载开始的最长时间
+)
+
+// ProgressHandler 返回以Server-Sent Events推送下载进度的处理器
+// 通过id参数指定下载ID，或通过url参数指定目标URL；只能查看本IP发起的下载
+//
+//	event: progress  下载状态，data为JSON
+//	event: end       下载已结束，data为最终状态
+//	event: notfound  等待超时仍未找到下载，data为错误信息
+func ProgressHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := utils.ClientIP(r)
+		id := r.URL.Query().Get("id")
+		targetURL := r.URL.Query().Get("url")
+		if id == "" && targetURL == "" {
+			utils.HTTPError(w, r, "缺少id或url参数", http.StatusBadRequest)
+			return
+		}
+		if targetURL != "" {
+			// 与代理请求使用相同的URL格式
+			if u, err := url.Parse(targetURL); err == nil {
+				targetURL = u.String()
+			}
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			utils.HTTPError(w, r, "不支持流式响应", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// 通过url订阅时忽略订阅前已经结束的下载记录
+		subscribed := time.Now()
+		lookup := func() (DownloadStatus, bool) {
+			if id != "" {
+				status, ok := downloadTracker.Download(id)
+				return status, ok && status.ClientIP == clientIP
+			}
+			status, ok := downloadTracker.DownloadByURL(clientIP, targetURL)
+			if ok && status.EndTime != nil && status.EndTime.Before(subscribed) {
+				return DownloadStatus{}, false
+			}
+			return status, ok
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		var last DownloadStatus
+		for {
+			status, found := lookup()
+			switch {
+			case found && status.State != downloadActive && status.State != downloadPaused:
+				writeEvent(w, "end", status)
+				flusher.Flush()
+				return
+			case found:
+				// 状态没有变化时不重复推送
+				if status.Bytes != last.Bytes || status.State != last.State || status.ActiveConns != last.ActiveConns {
+					writeEvent(w, "progress", status)
+					last = status
+				}
+			case time.Since(subscribed) > progressWaitTimeout:
+				writeEvent(w, "notfound", map[string]string{"error": "下载不存在"})
+				flusher.Flush()
+				return
+			default:
+				// 注释行用于保持连接
+				fmt.Fprint(w, ": waiting\n\n")
+			}
+			flusher.Flush()
+
+			select {
+			case <-ticker.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// writeEvent 输出一条Server-Sent Events消息
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// parseContentRange 解析形如"bytes 0-99/1000"的Content-Range头
+// 总大小未知("*")时total返回-1
+func parseContentRange(value string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+	}
+
+	rangePart, totalPart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+	}
+
+	total = -1
+	if totalPart != "*" {
+		if total, err = strconv.ParseInt(totalPart, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+		}
+	}
+
+	startPart, endPart, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+	}
+	if start, err = strconv.ParseInt(startPart, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+	}
+	if end, err = strconv.ParseInt(endPart, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("无效的Content-Range: %s", value)
+	}
+	return start, end, total, nil
+}
+
+// parseRangeHeader 解析客户端的Range头，返回按请求顺序排列的区间
+// 格式错误时ok为false，应忽略Range头；所有区间都无法满足时返回空切片
+func parseRangeHeader(value string, size int64) (ranges []byteRange, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(value), "bytes=")
+	if !found {
+		return nil, false
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		startPart, endPart, found := strings.Cut(part, "-")
+		if !found {
+			return nil, false
+		}
+		startPart, endPart = strings.TrimSpace(startPart), strings.TrimSpace(endPart)
+
+		var r byteRange
+		if startPart == "" {
+			// 后缀区间，如"-500"表示最后500字节
+			suffix, err := strconv.ParseInt(endPart, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, false
+			}
+			if suffix == 0 || size == 0 {
+				// 空文件没有可以返回的后缀区间(RFC 9110 14.1.1)
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			r = byteRange{start: size - suffix, end: size}
+		} else {
+			start, err := strconv.ParseInt(startPart, 10, 64)
+			if err != nil || start < 0 {
+				return nil, false
+			}
+			end := size - 1
+			if endPart != "" {
+				if end, err = strconv.ParseInt(endPart, 10, 64); err != nil || end < start {
+					return nil, false
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				// 超出文件大小的区间无法满足
+				continue
+			}
+			r = byteRange{start: start, end: end + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, true
+}
+
+// ifRangeMatches 判断客户端的If-Range条件是否与上游响应一致
+func ifRangeMatches(r *http.Request, resp *http.Response) bool {
+	ifRange := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// If-Range只能使用强ETag比较
+		etag := resp.Header.Get("ETag")
+		return !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	return ifRange == resp.Header.Get("Last-Modified")
+}
+
+// applyLocalRange 上游忽略Range请求返回完整文件时，由代理截取客户端请求的区间
+// 单个区间时改写为206响应，多个按升序排列且互不重叠的区间改写为multipart/byteranges响应，
+// 其他无法处理的情况保持完整的200响应
+// 返回false表示请求的区间无法满足，应返回416
+func applyLocalRange(r *http.Request, resp *http.Response) bool {
+	if r.Method != http.MethodGet || resp.ContentLength < 0 || !ifRangeMatches(r, resp) {
+		return true
+	}
+
+	ranges, ok := parseRangeHeader(r.Header.Get("Range"), resp.ContentLength)
+	if !ok {
+		return true
+	}
+	if len(ranges) == 0 {
+		return false
+	}
+	if len(ranges) > 1 {
+		// 响应体只能顺序读取一遍，乱序或重叠的区间按RFC 9110的允许忽略Range，直接返回完整文件
+		for i := 1; i < len(ranges); i++ {
+			if ranges[i].start < ranges[i-1].end {
+				return true
+			}
+		}
+		applyMultipartRange(resp, ranges)
+		return true
+	}
+
+	rng := ranges[0]
+	if _, err := io.CopyN(io.Discard, resp.Body, rng.start); err != nil {
+		// 跳过前缀失败，交由后续读取返回错误
+		return true
+	}
+
+	length := rng.end - rng.start
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end-1, resp.ContentLength))
+	resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	resp.StatusCode = http.StatusPartialContent
+	resp.ContentLength = length
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}
+	return true
+}
+
+// applyMultipartRange 将完整的上游响应改写为包含多个区间的multipart/byteranges响应
+// 响应体在后台按顺序跳过区间之间的数据，边读边写入各部分
+func applyMultipartRange(resp *http.Response, ranges []byteRange) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	size := resp.ContentLength
+	partHeader := func(rng byteRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end-1, size)},
+		}
+	}
+
+	// 预先用同一个分隔符生成各部分的头，计算Content-Length
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	for _, rng := range ranges {
+		mw.CreatePart(partHeader(rng))
+		counter += countingWriter(rng.end - rng.start)
+	}
+	mw.Close()
+	boundary := mw.Boundary()
+
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		mw := multipart.NewWriter(pw)
+		mw.SetBoundary(boundary)
+		var pos int64
+		for _, rng := range ranges {
+			if _, err := io.CopyN(io.Discard, body, rng.start-pos); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			part, err := mw.CreatePart(partHeader(rng))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.CopyN(part, body, rng.end-rng.start); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pos = rng.end
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+
+	length := int64(counter)
+	resp.Header.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	resp.Header.Del("Content-Range")
+	resp.StatusCode = http.StatusPartialContent
+	resp.ContentLength = length
+	resp.Body = &multipartBody{PipeReader: pr, body: body}
+}
+
+// countingWriter 只统计写入的字节数
+type countingWriter int64
+
+// Write 实现io.Writer接口
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartBody 多段响应的响应体，关闭时同时关闭上游响应体，使后台写入随之结束
+type multipartBody struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+// Close 实现io.Closer接口
+func (b *multipartBody) Close() error {
+	b.PipeReader.Close()
+	return b.body.Close()
+}
+
+// writeRangeNotSatisfiable 返回416响应
+func writeRangeNotSatisfiable(w http.ResponseWriter, r *http.Request, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	utils.HTTPError(w, r, "请求的范围无法满足", http.StatusRequestedRangeNotSatisfiable)
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		size   int64
+		want   []byteRange
+		wantOK bool
+	}{
+		{"单个区间", "bytes=0-99", 1000, []byteRange{{0, 100}}, true},
+		{"开放区间", "bytes=900-", 1000, []byteRange{{900, 1000}}, true},
+		{"结束位置超出文件", "bytes=900-5000", 1000, []byteRange{{900, 1000}}, true},
+		{"后缀区间", "bytes=-100", 1000, []byteRange{{900, 1000}}, true},
+		{"后缀超过文件大小", "bytes=-5000", 1000, []byteRange{{0, 1000}}, true},
+		{"多个区间", "bytes=0-9, 20-29", 1000, []byteRange{{0, 10}, {20, 30}}, true},
+		{"起始位置超出文件", "bytes=1000-", 1000, nil, true},
+		{"零长度后缀", "bytes=-0", 1000, nil, true},
+		{"空文件的后缀区间", "bytes=-5", 0, nil, true},
+		{"空文件的区间", "bytes=0-", 0, nil, true},
+		{"单位错误", "items=0-9", 1000, nil, false},
+		{"结束位置小于起始位置", "bytes=9-0", 1000, nil, false},
+		{"格式错误", "bytes=abc", 1000, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRangeHeader(tt.value, tt.size)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRangeHeader(%q, %d) = %v, %v，应为 %v, %v", tt.value, tt.size, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// fullResponse 模拟忽略了Range头的上游返回的完整响应
+func fullResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/plain"}, "Content-Length": {strconv.Itoa(len(body))}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func rangeRequest(value string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", value)
+	return r
+}
+
+func TestApplyLocalRange(t *testing.T) {
+	const body = "0123456789abcdefghij"
+
+	t.Run("单个区间", func(t *testing.T) {
+		resp := fullResponse(body)
+		if !applyLocalRange(rangeRequest("bytes=5-9"), resp) {
+			t.Fatal("区间应当可以满足")
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || string(data) != "56789" ||
+			resp.Header.Get("Content-Range") != "bytes 5-9/20" || resp.ContentLength != 5 {
+			t.Errorf("得到 %d %q Content-Range=%q Content-Length=%d",
+				resp.StatusCode, data, resp.Header.Get("Content-Range"), resp.ContentLength)
+		}
+	})
+
+	t.Run("多个区间", func(t *testing.T) {
+		resp := fullResponse(body)
+		if !applyLocalRange(rangeRequest("bytes=0-2,10-12,-2"), resp) {
+			t.Fatal("区间应当可以满足")
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || int64(len(data)) != resp.ContentLength {
+			t.Fatalf("状态码%d，响应体%d字节，Content-Length为%d", resp.StatusCode, len(data), resp.ContentLength)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type为%q", resp.Header.Get("Content-Type"))
+		}
+		mr := multipart.NewReader(strings.NewReader(string(data)), params["boundary"])
+		want := []struct{ contentRange, data string }{
+			{"bytes 0-2/20", "012"},
+			{"bytes 10-12/20", "abc"},
+			{"bytes 18-19/20", "ij"},
+		}
+		for _, w := range want {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("读取分段失败: %v", err)
+			}
+			got, _ := io.ReadAll(part)
+			if part.Header.Get("Content-Range") != w.contentRange || string(got) != w.data || part.Header.Get("Content-Type") != "text/plain" {
+				t.Errorf("分段为 %q %q，应为 %q %q", part.Header.Get("Content-Range"), got, w.contentRange, w.data)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("分段数量过多: %v", err)
+		}
+	})
+
+	t.Run("乱序区间返回完整文件", func(t *testing.T) {
+		resp := fullResponse(body)
+		if !applyLocalRange(rangeRequest("bytes=10-12,0-2"), resp) || resp.StatusCode != http.StatusOK {
+			t.Errorf("状态码为%d，应保持200", resp.StatusCode)
+		}
+	})
+
+	t.Run("无法满足", func(t *testing.T) {
+		if applyLocalRange(rangeRequest("bytes=100-"), fullResponse(body)) {
+			t.Error("超出文件大小的区间应返回416")
+		}
+	})
+
+	t.Run("空文件的后缀区间无法满足", func(t *testing.T) {
+		resp := fullResponse("")
+		if applyLocalRange(rangeRequest("bytes=-5"), resp) {
+			t.Errorf("空文件的后缀区间应返回416，实际Content-Range=%q", resp.Header.Get("Content-Range"))
+		}
+	})
+}
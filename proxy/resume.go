@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxResumeBackoff 续传前等待时间的上限
+const maxResumeBackoff = 30 * time.Second
+
+// resumableBody 包装上游响应体，传输中断时使用Range请求从断点继续
+// 续传请求携带If-Range，上游文件变化时放弃续传以免拼接出错误的数据
+type resumableBody struct {
+	ctx     context.Context
+	client  *http.Client
+	req     *http.Request // 续传请求的模板
+	ifRange string
+
+	body   io.ReadCloser
+	offset int64 // 下一个字节在文件中的位置
+	end    int64 // 预期结束位置（不含），-1表示未知
+
+	retries    int
+	maxRetries int
+	backoff    time.Duration
+}
+
+// maybeResumable 为可以续传的上游响应启用断点续传
+func (p *ProxyHandler) maybeResumable(proxyReq *http.Request, resp *http.Response) {
+	resumeCfg := p.config.Proxy.Resume
+	if !resumeCfg.Enabled || resumeCfg.MaxRetries <= 0 || proxyReq.Method != http.MethodGet {
+		return
+	}
+
+	var start, end int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+		start, end = 0, resp.ContentLength
+	case http.StatusPartialContent:
+		rangeStart, rangeEnd, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			// 多段响应无法续传
+			return
+		}
+		start, end = rangeStart, rangeEnd+1
+	default:
+		return
+	}
+
+	resp.Body = newResumableBody(p.client, proxyReq, resp, start, end, resumeCfg.MaxRetries, time.Duration(resumeCfg.Backoff)*time.Millisecond)
+}
+
+// newResumableBody 创建支持断点续传的响应体
+// start和end为响应体在完整文件中的位置，end为-1表示长度未知
+func newResumableBody(client *http.Client, req *http.Request, resp *http.Response, start, end int64, maxRetries int, backoff time.Duration) io.ReadCloser {
+	ifRange := rangeValidator(resp)
+	if ifRange == "" || resp.Header.Get("Content-Encoding") != "" {
+		// 没有校验值时无法保证续传的是同一个文件
+		return resp.Body
+	}
+
+	return &resumableBody{
+		ctx:        req.Context(),
+		client:     client,
+		req:        rangeRequestTemplate(req.Context(), req, resp),
+		ifRange:    ifRange,
+		body:       resp.Body,
+		offset:     start,
+		end:        end,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}
+}
+
+// Read 实现io.Reader接口
+func (rb *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := rb.body.Read(p)
+		rb.offset += int64(n)
+
+		if err == nil {
+			return n, nil
+		}
+		if err == io.EOF && (rb.end < 0 || rb.offset >= rb.end) {
+			return n, io.EOF
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		// 客户端取消或超时不再续传
+		if rb.ctx.Err() != nil || rb.retries >= rb.maxRetries {
+			return n, err
+		}
+		if resumeErr := rb.resume(err); resumeErr != nil {
+			slog.WarnContext(rb.ctx, "续传失败", "target_url", rb.req.URL.String(), "error", resumeErr)
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// backoffDelay 返回第retry次续传前的等待时间，每次翻倍且不超过maxResumeBackoff
+// 逐次翻倍而不是直接移位，重试次数很大时也不会溢出
+func backoffDelay(base time.Duration, retry int) time.Duration {
+	delay := base
+	for i := 1; i < retry && delay < maxResumeBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxResumeBackoff)
+}
+
+// resume 关闭中断的连接并从当前位置重新请求
+func (rb *resumableBody) resume(cause error) error {
+	rb.body.Close()
+
+	for rb.retries < rb.maxRetries {
+		rb.retries++
+
+		// 指数退避
+		delay := backoffDelay(rb.backoff, rb.retries)
+		slog.WarnContext(rb.ctx, "上游传输中断，准备续传",
+			"target_url", rb.req.URL.String(),
+			"error", cause,
+			"delay", delay,
+			"offset", rb.offset,
+			"retry", rb.retries,
+			"max_retries", rb.maxRetries)
+
+		select {
+		case <-time.After(delay):
+		case <-rb.ctx.Done():
+			return rb.ctx.Err()
+		}
+
+		body, err := rb.request()
+		if err == nil {
+			rb.body = body
+			return nil
+		}
+		cause = err
+		if rb.ctx.Err() != nil {
+			return err
+		}
+	}
+	return cause
+}
+
+// request 发起从当前位置开始的Range请求
+func (rb *resumableBody) request() (io.ReadCloser, error) {
+	req := rb.req.Clone(rb.ctx)
+	if rb.end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rb.offset, rb.end-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", rb.offset))
+	}
+	req.Header.Set("If-Range", rb.ifRange)
+
+	resp, err := rb.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("续传请求返回状态码%d，上游文件可能已变化", resp.StatusCode)
+	}
+	start, _, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || start != rb.offset {
+		resp.Body.Close()
+		return nil, fmt.Errorf("续传响应的Content-Range不匹配: %s", resp.Header.Get("Content-Range"))
+	}
+	return resp.Body, nil
+}
+
+// Close 关闭当前的上游连接
+func (rb *resumableBody) Close() error {
+	return rb.body.Close()
+}
+
+// rangeRequestTemplate 基于原始代理请求创建Range请求的模板
+// 直接请求重定向后的最终地址，并去掉客户端的条件头和Range头
+func rangeRequestTemplate(ctx context.Context, proxyReq *http.Request, resp *http.Response) *http.Request {
+	req := proxyReq.Clone(ctx)
+	if resp.Request != nil {
+		req.URL = resp.Request.URL
+	}
+	req.Host = ""
+	for _, header := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		req.Header.Del(header)
+	}
+	return req
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentedReader 使用多个并行的Range请求拉取上游文件，并按顺序重组为连续的数据流
+// 第一个分段直接复用原始响应体，其余分段由后台并发下载到内存中
+type segmentedReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	client  *http.Client
+	req     *http.Request // 分段请求的模板
+	ifRange string
+
+	// 分段传输中断时的续传设置
+	maxRetries int
+	backoff    time.Duration
+
+	total    int64
+	segSize  int64
+	segCount int
+
+	first   io.ReadCloser
+	results []chan segmentResult
+	slots   chan struct{} // 限制同时下载和缓存的分段数量
+
+	index     int    // 当前读取的分段
+	cur       []byte // 当前分段未读取的数据
+	firstRead int64
+	closeOnce sync.Once
+}
+
+// segmentResult 单个分段的下载结果
+type segmentResult struct {
+	data []byte
+	err  error
+}
+
+// maybeSegment 对满足条件的大文件响应启用多连接分段下载
+// 要求上游声明Accept-Ranges: bytes，文件大小超过chunkedThreshold，并且提供强ETag或Last-Modified
+func (p *ProxyHandler) maybeSegment(proxyReq *http.Request, resp *http.Response) {
+	segCfg := p.config.Proxy.Segmented
+	if !segCfg.Enabled || segCfg.Concurrency < 2 || segCfg.SegmentSize <= 0 {
+		return
+	}
+	if proxyReq.Method != http.MethodGet || proxyReq.Header.Get("Range") != "" {
+		return
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return
+	}
+	if resp.ContentLength <= p.config.Proxy.ChunkedThreshold || resp.ContentLength <= segCfg.SegmentSize {
+		return
+	}
+	if !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return
+	}
+	// 没有校验值时分段请求无法携带If-Range，上游文件在下载过程中变化会拼接出不同版本的数据
+	if rangeValidator(resp) == "" {
+		return
+	}
+
+	sr := newSegmentedReader(p.client, proxyReq, resp, segCfg.SegmentSize, segCfg.Concurrency)
+	if p.config.Proxy.Resume.Enabled {
+		sr.maxRetries = p.config.Proxy.Resume.MaxRetries
+		sr.backoff = time.Duration(p.config.Proxy.Resume.Backoff) * time.Millisecond
+	}
+	resp.Body = sr
+}
+
+// newSegmentedReader 创建分段读取器并启动后台下载
+func newSegmentedReader(client *http.Client, proxyReq *http.Request, resp *http.Response, segSize int64, concurrency int) *segmentedReader {
+	ctx, cancel := context.WithCancel(proxyReq.Context())
+	req := rangeRequestTemplate(ctx, proxyReq, resp)
+
+	segCount := int((resp.ContentLength + segSize - 1) / segSize)
+	sr := &segmentedReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		client:   client,
+		req:      req,
+		ifRange:  rangeValidator(resp),
+		total:    resp.ContentLength,
+		segSize:  segSize,
+		segCount: segCount,
+		first:    resp.Body,
+		results:  make([]chan segmentResult, segCount),
+		slots:    make(chan struct{}, concurrency),
+	}
+	for i := range sr.results {
+		sr.results[i] = make(chan segmentResult, 1)
+	}
+
+	slog.InfoContext(ctx, "分段下载",
+		"target_url", req.URL.String(),
+		"total_bytes", sr.total,
+		"segments", segCount,
+		"concurrency", concurrency)
+
+	go sr.dispatch()
+	return sr
+}
+
+// dispatch 按顺序启动分段下载，已缓存未读取的分段数不超过并发数
+func (sr *segmentedReader) dispatch() {
+	for i := 1; i < sr.segCount; i++ {
+		select {
+		case sr.slots <- struct{}{}:
+		case <-sr.ctx.Done():
+			return
+		}
+		go func(index int) {
+			data, err := sr.fetch(index)
+			sr.results[index] <- segmentResult{data: data, err: err}
+		}(i)
+	}
+}
+
+// fetch 下载指定分段的完整数据
+func (sr *segmentedReader) fetch(index int) ([]byte, error) {
+	start, end := sr.segmentBounds(index)
+
+	req := sr.req.Clone(sr.ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("If-Range", sr.ifRange)
+
+	resp, err := sr.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("分段%d请求返回状态码%d，上游文件可能已变化", index, resp.StatusCode)
+	}
+	gotStart, gotEnd, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if gotStart != start || gotEnd != end || (total >= 0 && total != sr.total) {
+		return nil, fmt.Errorf("分段%d的Content-Range不匹配: %s", index, resp.Header.Get("Content-Range"))
+	}
+
+	// 分段传输中断时同样从断点续传
+	body := resp.Body
+	if sr.maxRetries > 0 {
+		body = newResumableBody(sr.client, req, resp, start, end+1, sr.maxRetries, sr.backoff)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("读取分段%d失败: %v", index, err)
+	}
+	return data, nil
+}
+
+// segmentBounds 返回分段的起止字节位置（闭区间）
+func (sr *segmentedReader) segmentBounds(index int) (int64, int64) {
+	start := int64(index) * sr.segSize
+	end := start + sr.segSize - 1
+	if end >= sr.total {
+		end = sr.total - 1
+	}
+	return start, end
+}
+
+// Read 实现io.Reader接口，按分段顺序返回数据
+func (sr *segmentedReader) Read(p []byte) (int, error) {
+	if sr.index == 0 {
+		return sr.readFirst(p)
+	}
+
+	if len(sr.cur) == 0 {
+		if sr.index >= sr.segCount {
+			return 0, io.EOF
+		}
+		select {
+		case result := <-sr.results[sr.index]:
+			if result.err != nil {
+				return 0, result.err
+			}
+			sr.cur = result.data
+		case <-sr.ctx.Done():
+			return 0, sr.ctx.Err()
+		}
+	}
+
+	n := copy(p, sr.cur)
+	sr.cur = sr.cur[n:]
+	if len(sr.cur) == 0 {
+		// 当前分段读取完毕，释放名额以便下载后续分段
+		sr.cur = nil
+		sr.index++
+		<-sr.slots
+	}
+	return n, nil
+}
+
+// readFirst 从原始响应体中读取第一个分段
+func (sr *segmentedReader) readFirst(p []byte) (int, error) {
+	remaining := sr.segSize - sr.firstRead
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := sr.first.Read(p)
+	sr.firstRead += int64(n)
+
+	if sr.firstRead == sr.segSize {
+		// 第一个分段完成，关闭原始连接，后续数据由分段请求提供
+		sr.first.Close()
+		sr.index = 1
+		return n, nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close 停止所有分段下载并关闭原始响应体
+func (sr *segmentedReader) Close() error {
+	sr.closeOnce.Do(func() {
+		sr.cancel()
+		sr.first.Close()
+	})
+	return nil
+}
+
+// rangeValidator 返回可用于If-Range的校验值，弱ETag不能用于Range请求
+func rangeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/proxy-service/config"
+)
+
+func TestMaybeSegmentRequiresValidator(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Proxy.Segmented.Enabled = true
+	cfg.Proxy.Segmented.SegmentSize = 1024
+	cfg.Proxy.ChunkedThreshold = 0
+	p, err := newProxyHandler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		header    map[string]string
+		segmented bool
+	}{
+		{"无校验值", nil, false},
+		{"弱ETag", map[string]string{"ETag": `W/"v1"`}, false},
+		{"强ETag", map[string]string{"ETag": `"v1"`}, true},
+		{"Last-Modified", map[string]string{"Last-Modified": "Mon, 01 Jan 2024 00:00:00 GMT"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:1/file.bin", nil)
+
+			const size = 8192
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Accept-Ranges": {"bytes"}, "Content-Length": {strconv.Itoa(size)}},
+				ContentLength: size,
+				Body:          io.NopCloser(strings.NewReader(strings.Repeat("x", size))),
+			}
+			for k, v := range tt.header {
+				resp.Header.Set(k, v)
+			}
+
+			p.maybeSegment(req, resp)
+			sr, ok := resp.Body.(*segmentedReader)
+			if ok {
+				defer sr.Close()
+				if sr.ifRange == "" {
+					t.Error("分段请求必须携带If-Range")
+				}
+			}
+			if ok != tt.segmented {
+				t.Errorf("启用分段下载为%v，应为%v", ok, tt.segmented)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+
+	"github.com/yourusername/proxy-service/tracing"
+)
+
+// tracingTransport 为每个上游请求创建客户端span
+// 通过httptrace记录DNS解析、建立连接、TLS握手和等待首字节的耗时，
+// 重定向、分段和续传请求各自对应一个span
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip 实现http.RoundTripper接口
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "upstream "+req.Method, tracing.KindClient)
+	if !span.IsRecording() {
+		return t.next.RoundTrip(req)
+	}
+
+	span.SetAttributes(
+		"http.request.method", req.Method,
+		"url.full", redactURL(req.URL),
+		"server.address", req.URL.Hostname())
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		span.SetAttributes("http.request.header.range", rangeHeader)
+	}
+
+	// RoundTripper不能修改原请求，在副本上附加httptrace和traceparent头
+	ct := &clientTrace{ctx: ctx, span: span, connects: make(map[string]*tracing.Span)}
+	req = req.Clone(httptrace.WithClientTrace(ctx, ct.hooks()))
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := t.next.RoundTrip(req)
+	ct.finish(err)
+
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttributes(
+			"http.response.status_code", resp.StatusCode,
+			"http.response.content_length", resp.ContentLength)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatusError(resp.Status)
+		}
+	}
+	span.End()
+	return resp, err
+}
+
+// redactURL 返回可以记录到span中的URL，用户信息和查询参数的值替换为REDACTED
+// 签名下载链接的查询参数中常带有凭据，导出到追踪后端会被有权查看追踪的人看到
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if u.User != nil {
+		redacted.User = url.UserPassword("REDACTED", "REDACTED")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, values := range query {
+			for i := range values {
+				values[i] = "REDACTED"
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+// clientTrace 记录一次上游请求中各个阶段的子span
+// 同时拨号多个地址时httptrace的回调会并发执行，需要加锁
+type clientTrace struct {
+	ctx  context.Context
+	span *tracing.Span
+
+	mu       sync.Mutex
+	dns      *tracing.Span
+	connects map[string]*tracing.Span
+	tls      *tracing.Span
+	ttfb     *tracing.Span
+}
+
+// hooks 返回httptrace回调
+func (ct *clientTrace) hooks() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			ct.span.SetAttributes("network.peer.host", hostPort)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.span.SetAttributes("network.connection.reused", info.Reused)
+			if info.Conn != nil {
+				ct.span.SetAttributes("network.peer.address", info.Conn.RemoteAddr().String())
+			}
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			_, ct.dns = tracing.Start(ct.ctx, "dns.lookup", tracing.KindInternal)
+			ct.dns.SetAttributes("dns.host", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.dns.SetAttributes("dns.addresses", fmt.Sprint(info.Addrs))
+			ct.dns.SetError(info.Err)
+			ct.dns.End()
+			ct.dns = nil
+		},
+		ConnectStart: func(network, addr string) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			_, span := tracing.Start(ct.ctx, "upstream.connect", tracing.KindInternal)
+			span.SetAttributes("network.transport", network, "network.peer.address", addr)
+			ct.connects[network+" "+addr] = span
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			key := network + " " + addr
+			if span := ct.connects[key]; span != nil {
+				span.SetError(err)
+				span.End()
+				delete(ct.connects, key)
+			}
+		},
+		TLSHandshakeStart: func() {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			_, ct.tls = tracing.Start(ct.ctx, "tls.handshake", tracing.KindInternal)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.tls.SetAttributes(
+				"tls.protocol.version", tls.VersionName(state.Version),
+				"tls.server.name", state.ServerName,
+				"tls.resumed", state.DidResume)
+			ct.tls.SetError(err)
+			ct.tls.End()
+			ct.tls = nil
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			if info.Err != nil {
+				return
+			}
+			_, ct.ttfb = tracing.Start(ct.ctx, "upstream.ttfb", tracing.KindInternal)
+		},
+		GotFirstResponseByte: func() {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.ttfb.End()
+			ct.ttfb = nil
+		},
+	}
+}
+
+// finish 在请求结束时关闭仍未结束的子span，如等待首字节时连接失败
+func (ct *clientTrace) finish(err error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	for _, span := range []*tracing.Span{ct.dns, ct.tls, ct.ttfb} {
+		span.SetError(err)
+		span.End()
+	}
+	ct.dns, ct.tls, ct.ttfb = nil, nil, nil
+}
@@ -0,0 +1,686 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/yourusername/proxy-service/utils"
+)
+
+// DownloadTracker 用于跟踪文件下载状态
+// 同一客户端对同一URL的多个连接（如下载工具的分片请求）合并为一次下载
+// 同时记录每个客户端、每个目标主机正在进行的传输数，供并发限制使用
+type DownloadTracker struct {
+	downloads map[string]*DownloadInfo
+	mu        sync.RWMutex
+
+	conns ConnectionCounts
+	// 有传输结束时关闭并替换，用于唤醒排队的请求
+	connReleased chan struct{}
+}
+
+// ConnectionCounts 正在进行的传输数，按客户端IP和目标主机分别统计
+type ConnectionCounts struct {
+	Total    int
+	ByClient map[string]int
+	ByHost   map[string]int
+}
+
+// DownloadInfo 存储下载信息
+type DownloadInfo struct {
+	id          string
+	requestID   string // 发起下载的请求ID，分片连接沿用首个请求的ID
+	key         string
+	url         string
+	fileName    string
+	totalSize   int64
+	ranges      []byteRange // 已传输的字节区间，按起始位置排序且互不重叠
+	covered     int64       // ranges覆盖的字节数
+	unranged    int64       // 无法确定位置的字节数（如多段响应）
+	transferred int64       // 实际发送的字节数，重叠的区间会重复计算
+	startTime   time.Time
+	lastLogTime time.Time
+	lastActive  time.Time
+	endTime     time.Time
+	isComplete  bool
+	outcome     string // 结束时的状态，见download*常量
+	cancelled   bool   // 已被管理接口取消
+	activeConns int
+	clientIP    string
+	// 各连接取消传输的函数，供管理接口取消下载
+	cancels map[*trackedWriter]context.CancelCauseFunc
+	mu      sync.Mutex
+}
+
+// 下载状态
+const (
+	downloadActive    = "active"    // 有正在传输的连接
+	downloadPaused    = "paused"    // 只传输了部分区间，等待客户端继续请求
+	downloadCompleted = "completed" // 下载完成
+	downloadCancelled = "cancelled" // 客户端断开连接
+	downloadAborted   = "aborted"   // 被管理接口取消
+	downloadFailed    = "failed"    // 传输出错
+)
+
+// errDownloadAborted 管理接口取消传输时使用的原因
+var errDownloadAborted = errors.New("下载已被管理员取消")
+
+// byteRange 表示文件中的一个左闭右开字节区间
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// NewDownloadTracker 创建新的下载跟踪器
+func NewDownloadTracker() *DownloadTracker {
+	return &DownloadTracker{
+		downloads: make(map[string]*DownloadInfo),
+		conns: ConnectionCounts{
+			ByClient: make(map[string]int),
+			ByHost:   make(map[string]int),
+		},
+		connReleased: make(chan struct{}),
+	}
+}
+
+// tryAcquireConn 在未超过限制时登记一个传输，超限时返回超限的范围和变化通知通道
+// 限制值为0表示不限制
+func (dt *DownloadTracker) tryAcquireConn(clientIP, host string, limits connLimits) (string, <-chan struct{}) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	switch {
+	case limits.perClient > 0 && dt.conns.ByClient[clientIP] >= limits.perClient:
+		return "client", dt.connReleased
+	case limits.perHost > 0 && dt.conns.ByHost[host] >= limits.perHost:
+		return "host", dt.connReleased
+	case limits.global > 0 && dt.conns.Total >= limits.global:
+		return "global", dt.connReleased
+	}
+
+	dt.conns.Total++
+	dt.conns.ByClient[clientIP]++
+	dt.conns.ByHost[host]++
+	return "", nil
+}
+
+// releaseConn 传输结束时减少计数并唤醒排队的请求
+func (dt *DownloadTracker) releaseConn(clientIP, host string) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	dt.conns.Total--
+	if dt.conns.ByClient[clientIP]--; dt.conns.ByClient[clientIP] <= 0 {
+		delete(dt.conns.ByClient, clientIP)
+	}
+	if dt.conns.ByHost[host]--; dt.conns.ByHost[host] <= 0 {
+		delete(dt.conns.ByHost, host)
+	}
+
+	close(dt.connReleased)
+	dt.connReleased = make(chan struct{})
+}
+
+// ActiveDownloads 返回仍有活跃连接的下载数
+func (dt *DownloadTracker) ActiveDownloads() int {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	active := 0
+	for _, info := range dt.downloads {
+		info.mu.Lock()
+		if info.activeConns > 0 {
+			active++
+		}
+		info.mu.Unlock()
+	}
+	return active
+}
+
+// ActiveConnections 返回当前正在进行的传输数的快照
+func (dt *DownloadTracker) ActiveConnections() ConnectionCounts {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	counts := ConnectionCounts{
+		Total:    dt.conns.Total,
+		ByClient: make(map[string]int, len(dt.conns.ByClient)),
+		ByHost:   make(map[string]int, len(dt.conns.ByHost)),
+	}
+	for ip, n := range dt.conns.ByClient {
+		counts.ByClient[ip] = n
+	}
+	for host, n := range dt.conns.ByHost {
+		counts.ByHost[host] = n
+	}
+	return counts
+}
+
+// GetOrCreate 获取或创建下载信息
+// totalSize为完整文件的大小，未知时为-1
+func (dt *DownloadTracker) GetOrCreate(url string, fileName string, totalSize int64, clientIP string, requestID string) *DownloadInfo {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	// 使用客户端IP和URL作为唯一标识
+	key := clientIP + " " + url
+
+	info, exists := dt.downloads[key]
+	if exists {
+		info.mu.Lock()
+		finished := info.isComplete && info.activeConns == 0
+		info.mu.Unlock()
+		// 已结束的下载重新开始时作为新的下载记录
+		if finished {
+			exists = false
+		}
+	}
+
+	if !exists {
+		now := time.Now()
+		info = &DownloadInfo{
+			id:          uuid.NewString(),
+			requestID:   requestID,
+			key:         key,
+			url:         url,
+			fileName:    fileName,
+			totalSize:   totalSize,
+			startTime:   now,
+			lastLogTime: now,
+			lastActive:  now,
+			clientIP:    clientIP,
+			cancels:     make(map[*trackedWriter]context.CancelCauseFunc),
+		}
+		dt.downloads[key] = info
+
+		// 记录下载开始
+		slog.Info("开始下载", append(info.logAttrs(),
+			"total_bytes", totalSize)...)
+	}
+
+	info.mu.Lock()
+	// 后续的Range请求可能带来更准确的文件大小
+	if info.totalSize < 0 && totalSize >= 0 {
+		info.totalSize = totalSize
+	}
+	// 增加活跃连接计数
+	info.activeConns++
+	info.lastActive = time.Now()
+	info.mu.Unlock()
+
+	return info
+}
+
+// UpdateProgress 记录从offset开始传输的n个字节，offset为-1表示位置未知
+func (di *DownloadInfo) UpdateProgress(offset int64, n int64) {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+
+	di.transferred += n
+	if offset >= 0 {
+		di.addRange(byteRange{start: offset, end: offset + n})
+	} else {
+		di.unranged += n
+	}
+
+	// 每10秒记录一次进度
+	now := time.Now()
+	di.lastActive = now
+	if now.Sub(di.lastLogTime) >= 10*time.Second && !di.isComplete {
+		di.logProgress()
+		di.lastLogTime = now
+	}
+}
+
+// addRange 合并新传输的区间，重叠部分不重复计入下载量
+func (di *DownloadInfo) addRange(r byteRange) {
+	if r.end <= r.start {
+		return
+	}
+
+	// 找到第一个可能与新区间相交或相邻的区间
+	i := sort.Search(len(di.ranges), func(i int) bool {
+		return di.ranges[i].end >= r.start
+	})
+
+	merged := r
+	var replaced int64
+	j := i
+	for j < len(di.ranges) && di.ranges[j].start <= r.end {
+		if di.ranges[j].start < merged.start {
+			merged.start = di.ranges[j].start
+		}
+		if di.ranges[j].end > merged.end {
+			merged.end = di.ranges[j].end
+		}
+		replaced += di.ranges[j].end - di.ranges[j].start
+		j++
+	}
+
+	if i == j {
+		di.ranges = append(di.ranges, byteRange{})
+		copy(di.ranges[i+1:], di.ranges[i:])
+		di.ranges[i] = merged
+	} else {
+		di.ranges[i] = merged
+		di.ranges = append(di.ranges[:i+1], di.ranges[j:]...)
+	}
+	di.covered += merged.end - merged.start - replaced
+}
+
+// downloaded 返回已下载的字节数
+func (di *DownloadInfo) downloaded() int64 {
+	return di.covered + di.unranged
+}
+
+// logAttrs 返回所有下载日志共有的字段
+func (di *DownloadInfo) logAttrs() []any {
+	return []any{
+		"download_id", di.id,
+		"request_id", di.requestID,
+		"client_ip", di.clientIP,
+		"target_url", di.url,
+		"file_name", di.fileName,
+	}
+}
+
+// logProgress 记录当前下载进度
+func (di *DownloadInfo) logProgress() {
+	elapsedTime := time.Since(di.startTime)
+	speedMBps := float64(di.transferred) / elapsedTime.Seconds() / 1024 / 1024
+	downloaded := di.downloaded()
+
+	attrs := append(di.logAttrs(),
+		"bytes", downloaded,
+		"total_bytes", di.totalSize,
+		"duration", elapsedTime,
+		"speed_mbps", speedMBps,
+		"active_conns", di.activeConns)
+	if di.totalSize > 0 {
+		attrs = append(attrs, "percent", float64(downloaded)*100/float64(di.totalSize))
+	}
+	slog.Info("下载进度", attrs...)
+}
+
+// ConnectionClosed 标记一个连接已关闭，返回下载是否已结束
+func (dt *DownloadTracker) ConnectionClosed(info *DownloadInfo, err error) bool {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	// 减少活跃连接计数
+	info.activeConns--
+	info.lastActive = time.Now()
+
+	// 如果还有活跃连接，则不是最终状态
+	if info.activeConns > 0 || info.isComplete {
+		return false
+	}
+
+	downloaded := info.downloaded()
+	finished := info.totalSize > 0 && downloaded >= info.totalSize
+
+	switch {
+	case finished || (err == nil && info.totalSize <= 0):
+		// 下载完成
+		info.finish(downloadCompleted)
+		downloadDuration := time.Since(info.startTime)
+		speedMBps := float64(info.transferred) / downloadDuration.Seconds() / 1024 / 1024
+
+		slog.Info("下载完成", append(info.logAttrs(),
+			"bytes", downloaded,
+			"duration", downloadDuration,
+			"speed_mbps", speedMBps)...)
+		return true
+	case err == nil:
+		// 只传输了部分区间，等待客户端继续请求剩余部分
+		return false
+	case info.cancelled:
+		// 管理员取消下载
+		info.finish(downloadAborted)
+		slog.Warn("下载已被管理员取消", append(info.logAttrs(),
+			"bytes", downloaded,
+			"duration", time.Since(info.startTime))...)
+		return true
+	case isClientDisconnectError(err):
+		// 客户端取消下载
+		info.finish(downloadCancelled)
+		slog.Info("下载取消: 客户端断开连接", append(info.logAttrs(),
+			"bytes", downloaded,
+			"duration", time.Since(info.startTime))...)
+		return true
+	default:
+		// 下载出错
+		info.finish(downloadFailed)
+		slog.Warn("下载错误", append(info.logAttrs(),
+			"bytes", downloaded,
+			"duration", time.Since(info.startTime),
+			"error", err)...)
+		return true
+	}
+}
+
+// finish 记录下载的最终状态
+func (di *DownloadInfo) finish(outcome string) {
+	di.isComplete = true
+	di.outcome = outcome
+	di.endTime = time.Now()
+}
+
+// state 返回下载当前的状态
+func (di *DownloadInfo) state() string {
+	switch {
+	case di.activeConns > 0:
+		return downloadActive
+	case !di.isComplete:
+		return downloadPaused
+	default:
+		return di.outcome
+	}
+}
+
+// register 登记连接的取消函数
+func (di *DownloadInfo) register(tw *trackedWriter, cancel context.CancelCauseFunc) {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	di.cancels[tw] = cancel
+}
+
+// unregister 连接结束时移除其取消函数
+func (di *DownloadInfo) unregister(tw *trackedWriter) {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	delete(di.cancels, tw)
+}
+
+// DownloadStatus 下载状态的快照，供管理接口输出
+type DownloadStatus struct {
+	ID           string     `json:"id"`
+	RequestID    string     `json:"request_id,omitempty"`
+	URL          string     `json:"target_url"`
+	FileName     string     `json:"file_name"`
+	ClientIP     string     `json:"client_ip"`
+	State        string     `json:"state"`
+	TotalBytes   int64      `json:"total_bytes"`
+	Bytes        int64      `json:"bytes"`
+	Transferred  int64      `json:"transferred_bytes"`
+	Percent      float64    `json:"percent,omitempty"`
+	SpeedBps     float64    `json:"speed_bps"`
+	ActiveConns  int        `json:"active_conns"`
+	StartTime    time.Time  `json:"start_time"`
+	EndTime      *time.Time `json:"end_time,omitempty"`
+	DurationSecs float64    `json:"duration_seconds"`
+}
+
+// status 返回下载的状态快照
+func (di *DownloadInfo) status() DownloadStatus {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+
+	end := time.Now()
+	if di.isComplete && di.activeConns == 0 {
+		end = di.endTime
+	} else if di.activeConns == 0 {
+		end = di.lastActive
+	}
+	duration := end.Sub(di.startTime)
+
+	status := DownloadStatus{
+		ID:           di.id,
+		RequestID:    di.requestID,
+		URL:          di.url,
+		FileName:     di.fileName,
+		ClientIP:     di.clientIP,
+		State:        di.state(),
+		TotalBytes:   di.totalSize,
+		Bytes:        di.downloaded(),
+		Transferred:  di.transferred,
+		ActiveConns:  di.activeConns,
+		StartTime:    di.startTime,
+		DurationSecs: duration.Seconds(),
+	}
+	if di.totalSize > 0 {
+		status.Percent = float64(status.Bytes) * 100 / float64(di.totalSize)
+	}
+	if duration > 0 {
+		status.SpeedBps = float64(di.transferred) / duration.Seconds()
+	}
+	if di.isComplete && di.activeConns == 0 {
+		endTime := di.endTime
+		status.EndTime = &endTime
+	}
+	return status
+}
+
+// Downloads 返回所有下载记录的快照，按开始时间从新到旧排列
+// 已结束的记录保留到被定期清理为止
+func (dt *DownloadTracker) Downloads() []DownloadStatus {
+	dt.mu.RLock()
+	infos := make([]*DownloadInfo, 0, len(dt.downloads))
+	for _, info := range dt.downloads {
+		infos = append(infos, info)
+	}
+	dt.mu.RUnlock()
+
+	statuses := make([]DownloadStatus, 0, len(infos))
+	for _, info := range infos {
+		statuses = append(statuses, info.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartTime.After(statuses[j].StartTime)
+	})
+	return statuses
+}
+
+// Download 按ID查找下载记录
+func (dt *DownloadTracker) Download(id string) (DownloadStatus, bool) {
+	if info := dt.find(id); info != nil {
+		return info.status(), true
+	}
+	return DownloadStatus{}, false
+}
+
+// DownloadByURL 查找客户端对目标URL的下载记录
+func (dt *DownloadTracker) DownloadByURL(clientIP, url string) (DownloadStatus, bool) {
+	dt.mu.RLock()
+	info := dt.downloads[clientIP+" "+url]
+	dt.mu.RUnlock()
+	if info == nil {
+		return DownloadStatus{}, false
+	}
+	return info.status(), true
+}
+
+// Cancel 取消下载的所有进行中的连接
+// 下载不存在时返回false，下载没有进行中的连接时返回错误
+func (dt *DownloadTracker) Cancel(id string) (bool, error) {
+	info := dt.find(id)
+	if info == nil {
+		return false, nil
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if len(info.cancels) == 0 {
+		return true, fmt.Errorf("下载没有正在进行的传输")
+	}
+	info.cancelled = true
+	for _, cancel := range info.cancels {
+		cancel(errDownloadAborted)
+	}
+	return true, nil
+}
+
+// find 按ID查找下载信息
+func (dt *DownloadTracker) find(id string) *DownloadInfo {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	for _, info := range dt.downloads {
+		if info.id == id {
+			return info
+		}
+	}
+	return nil
+}
+
+// transferCancelKey 请求上下文中保存取消传输函数的键
+type transferCancelKey struct{}
+
+// withTransferCancel 创建可由管理接口取消的请求上下文
+func withTransferCancel(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return context.WithValue(ctx, transferCancelKey{}, cancel), cancel
+}
+
+// CleanupOldDownloads 清理结束或闲置超过一定时间的下载记录
+func (dt *DownloadTracker) CleanupOldDownloads() {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	threshold := time.Now().Add(-30 * time.Minute)
+	for key, info := range dt.downloads {
+		info.mu.Lock()
+		if info.activeConns == 0 && info.lastActive.Before(threshold) {
+			delete(dt.downloads, key)
+		}
+		info.mu.Unlock()
+	}
+}
+
+// 定期清理旧的下载记录
+func init() {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			downloadTracker.CleanupOldDownloads()
+		}
+	}()
+}
+
+// trackedWriter 是一个包装了http.ResponseWriter的结构，用于跟踪下载进度
+// 在写入响应头时根据状态码和Content-Range确定本次传输在文件中的位置
+// 写入数据前按带宽限制等待，今日流量配额用完时中断传输
+type trackedWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	url       string
+	fileName  string
+	clientIP  string
+	method    string
+	bandwidth *bandwidthLimiter
+
+	downloadInfo *DownloadInfo
+	offset       int64
+	wroteHeader  bool
+	err          error
+}
+
+// newTrackedWriter 创建跟踪下载进度的响应写入器
+func newTrackedWriter(w http.ResponseWriter, r *http.Request, url string, fileName string, clientIP string, bandwidth *bandwidthLimiter) *trackedWriter {
+	return &trackedWriter{
+		ResponseWriter: w,
+		ctx:            r.Context(),
+		url:            url,
+		fileName:       fileName,
+		clientIP:       clientIP,
+		method:         r.Method,
+		bandwidth:      bandwidth,
+		offset:         -1,
+	}
+}
+
+// WriteHeader 只有返回文件内容的响应才会登记为下载
+func (tw *trackedWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.begin(status)
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// begin 根据响应头登记下载
+func (tw *trackedWriter) begin(status int) {
+	if tw.method == http.MethodHead {
+		return
+	}
+
+	header := tw.Header()
+	totalSize := int64(-1)
+	switch status {
+	case http.StatusOK:
+		tw.offset = 0
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			totalSize = length
+		}
+	case http.StatusPartialContent:
+		// 多段响应没有Content-Range头，无法确定位置
+		if start, _, total, err := parseContentRange(header.Get("Content-Range")); err == nil {
+			tw.offset = start
+			totalSize = total
+		}
+	default:
+		return
+	}
+
+	tw.downloadInfo = downloadTracker.GetOrCreate(tw.url, tw.fileName, totalSize, tw.clientIP, utils.RequestID(tw.ctx))
+	if cancel, ok := tw.ctx.Value(transferCancelKey{}).(context.CancelCauseFunc); ok {
+		tw.downloadInfo.register(tw, cancel)
+	}
+	// 客户端可以用下载ID查询进度
+	header.Set("X-Download-ID", tw.downloadInfo.id)
+}
+
+// Write 实现io.Writer接口
+func (tw *trackedWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+
+	// 按带宽限制等待，流量配额在传输过程中用完时只发送剩余配额内的数据
+	allowed, err := tw.bandwidth.throttle(tw.ctx, tw.clientIP, len(p))
+	if err != nil && !errors.Is(err, errQuotaExceeded) {
+		tw.err = err
+		return 0, err
+	}
+	quotaErr := err
+
+	n, err := tw.ResponseWriter.Write(p[:allowed])
+	if err != nil {
+		tw.err = err
+		return n, err
+	}
+
+	// 更新下载进度
+	if tw.downloadInfo != nil {
+		tw.downloadInfo.UpdateProgress(tw.offset, int64(n))
+		if tw.offset >= 0 {
+			tw.offset += int64(n)
+		}
+	}
+
+	// 中断传输，客户端次日可以用Range请求继续下载
+	if quotaErr != nil {
+		limitRejections.WithLabelValues("quota").Inc()
+		tw.err = quotaErr
+		return n, quotaErr
+	}
+	return n, nil
+}
+
+// finish 在传输结束时通知下载跟踪器
+func (tw *trackedWriter) finish(err error) {
+	if tw.downloadInfo != nil {
+		tw.downloadInfo.unregister(tw)
+		downloadTracker.ConnectionClosed(tw.downloadInfo, err)
+	}
+}
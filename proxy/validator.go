@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"fmt"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -14,12 +16,6 @@ var (
 
 // ValidateURL 验证URL格式是否有效
 func ValidateURL(targetURL *url.URL) error {
-	// 对于常见的可信网站直接允许
-	if strings.HasSuffix(targetURL.Host, "github.com") ||
-		strings.HasSuffix(targetURL.Host, "githubusercontent.com") {
-		return nil
-	}
-
 	urlStr := targetURL.String()
 
 	// 检查协议
@@ -32,6 +28,13 @@ func ValidateURL(targetURL *url.URL) error {
 		return fmt.Errorf("主机名不能为空")
 	}
 
+	// 对于常见的可信网站跳过格式检查
+	host := targetURL.Hostname()
+	if matchHostPattern(host, ".github.com") ||
+		matchHostPattern(host, ".githubusercontent.com") {
+		return nil
+	}
+
 	// 使用正则表达式检查完整URL格式
 	if !validURLRegex.MatchString(urlStr) {
 		return fmt.Errorf("URL格式无效: %s", urlStr)
@@ -39,3 +42,64 @@ func ValidateURL(targetURL *url.URL) error {
 
 	return nil
 }
+
+// targetError 表示目标地址未通过安全检查
+type targetError struct {
+	status int
+	msg    string
+}
+
+func (e *targetError) Error() string {
+	return e.msg
+}
+
+// checkTarget 对目标地址执行安全检查，初始请求和每一跳重定向都会调用
+func (p *ProxyHandler) checkTarget(targetURL *url.URL) *targetError {
+	// 验证URL格式
+	if err := ValidateURL(targetURL); err != nil {
+		return &targetError{
+			status: http.StatusBadRequest,
+			msg:    fmt.Sprintf("URL验证失败: %v", err),
+		}
+	}
+
+	// 检查主机的允许和禁止规则
+	if err := p.hostPolicy.check(targetURL.Hostname()); err != nil {
+		return err
+	}
+
+	// 检查是否为内网地址，域名在拨号时按实际解析结果检查
+	if p.netPolicy.blockedHost(targetURL.Hostname()) {
+		return &targetError{
+			status: http.StatusForbidden,
+			msg:    fmt.Sprintf("不允许访问内网地址: %s", targetURL.Host),
+		}
+	}
+
+	return nil
+}
+
+// checkRedirect 作为http.Client的重定向策略，限制跳数并校验每一跳的目标地址
+func (p *ProxyHandler) checkRedirect(req *http.Request, via []*http.Request) error {
+	chain := make([]string, 0, len(via)+1)
+	for _, prev := range via {
+		chain = append(chain, prev.URL.String())
+	}
+	chain = append(chain, req.URL.String())
+
+	if maxHops := p.config.Security.Redirects.MaxHops; len(via) > maxHops {
+		slog.WarnContext(req.Context(), "重定向次数超过上限", "max_hops", maxHops, "chain", strings.Join(chain, " -> "))
+		return fmt.Errorf("重定向次数超过上限(%d)", maxHops)
+	}
+
+	if err := p.checkTarget(req.URL); err != nil {
+		slog.WarnContext(req.Context(), "重定向被拒绝", "chain", strings.Join(chain, " -> "), "error", err)
+		return err
+	}
+
+	// 只对原主机生效的请求头不随重定向发送给其他主机
+	p.headers.redirect(req.Header, via[0].URL.Hostname(), req.URL.Hostname())
+
+	slog.InfoContext(req.Context(), "跟随重定向", "hop", len(via), "chain", strings.Join(chain, " -> "))
+	return nil
+}
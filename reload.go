@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourusername/proxy-service/config"
+	"github.com/yourusername/proxy-service/metrics"
+	"github.com/yourusername/proxy-service/proxy"
+	"github.com/yourusername/proxy-service/utils"
+	"github.com/yourusername/proxy-service/web"
+)
+
+// restartFields 修改后需要重启服务才能生效的配置项，按前缀匹配
+var restartFields = []string{
+	"server.host",
+	"server.port",
+	"server.proxyProtocol",
+	"proxy.cache.enabled",
+	"proxy.cache.dir",
+	"proxy.cache.maxSize",
+	"proxy.coalescing",
+	"logging.format",
+	"tracing",
+	"reload",
+}
+
+// service 根据一份配置创建的处理链及其有状态的组件
+type service struct {
+	cfg         *config.Config
+	proxy       *proxy.ProxyHandler
+	rateLimiter proxy.Limiter
+	handler     http.Handler
+}
+
+// newService 根据配置创建处理链
+// prev不为空时沿用其中的磁盘缓存、下载合并和流量统计，限流配置未变化时沿用原限流器
+func newService(cfg *config.Config, prev *service) (*service, error) {
+	svc := &service{cfg: cfg}
+
+	// 代理处理器
+	var err error
+	if prev == nil {
+		svc.proxy, err = proxy.NewProxyHandler(cfg)
+	} else {
+		svc.proxy, err = prev.proxy.Reload(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("初始化代理处理器失败: %v", err)
+	}
+
+	// 注册静态资源和主页
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
+	mux.Handle("/", rootHandler(web.HomeHandler(), svc.proxy))
+	mux.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}))
+	// Prometheus监控指标
+	if cfg.Metrics.Enabled {
+		mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+	}
+	// 下载进度推送
+	mux.Handle(proxy.ProgressPath, proxy.ProgressHandler())
+	// 管理接口，Validate保证已设置访问令牌
+	if cfg.Admin.Enabled {
+		mux.Handle(proxy.AdminPrefix, proxy.AdminHandler(cfg.Admin.Token))
+	}
+	// 所有其他请求都交给代理处理器
+	mux.Handle("/*", svc.proxy)
+
+	// 初始化请求限制器
+	svc.handler = mux
+	if rateCfg := cfg.Security.RateLimiting; rateCfg.Enabled {
+		if prev != nil && prev.rateLimiter != nil &&
+			reflect.DeepEqual(prev.cfg.Security.RateLimiting, rateCfg) {
+			svc.rateLimiter = prev.rateLimiter
+		} else {
+			svc.rateLimiter, err = proxy.NewLimiter(rateCfg.Algorithm, rateCfg.RequestsPerMinute, rateCfg.Burst)
+			if err != nil {
+				return nil, fmt.Errorf("初始化请求限制器失败: %v", err)
+			}
+		}
+		svc.handler = proxy.LimitRate(svc.rateLimiter, rateCfg.ExemptPaths, mux)
+	}
+	svc.handler = withWriteDeadline(time.Duration(cfg.Proxy.TransferTimeout)*time.Second, svc.handler)
+
+	return svc, nil
+}
+
+// withWriteDeadline 按配置的传输超时为每个请求设置写超时
+// 写超时随处理链一起替换，修改transferTimeout后对新请求生效，不需要重启服务
+func withWriteDeadline(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Now().Add(timeout))
+		// 服务器没有设置WriteTimeout，不会在下一个请求开始时重置写超时，结束时清除以免影响同一连接上的后续请求
+		defer rc.SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// liveHandler 将请求交给当前生效的处理链，重新加载配置时原子替换
+// 已经开始的请求继续由原处理链处理
+type liveHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+// store 替换当前的处理链
+func (h *liveHandler) store(handler http.Handler) {
+	h.current.Store(&handler)
+}
+
+// ServeHTTP 实现http.Handler接口
+func (h *liveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+// reloader 重新加载配置文件，新配置无效时保留当前配置
+type reloader struct {
+	load     func() (*config.Config, error)
+	resolver *utils.ClientIPResolver
+	live     *liveHandler
+
+	current *service
+	mu      sync.Mutex
+}
+
+// reload 读取配置文件并替换处理链，trigger为触发原因
+func (rl *reloader) reload(trigger string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cfg, err := rl.load()
+	if err != nil {
+		logConfigError("重新加载配置失败，继续使用当前配置", err, "trigger", trigger)
+		return
+	}
+
+	changes := config.Diff(rl.current.cfg, cfg)
+	if len(changes) == 0 {
+		slog.Info("配置未变化", "trigger", trigger)
+		return
+	}
+
+	// 先校验所有新配置，全部有效后才替换
+	level, err := utils.ParseLogLevel(cfg.Logging.Level)
+	if err != nil {
+		slog.Error("重新加载配置失败，继续使用当前配置", "trigger", trigger, "error", err)
+		return
+	}
+	trusted, err := utils.ParsePrefixes(cfg.Server.TrustedProxies)
+	if err != nil {
+		slog.Error("重新加载配置失败，继续使用当前配置", "trigger", trigger, "error", fmt.Errorf("解析可信代理列表失败: %v", err))
+		return
+	}
+	svc, err := newService(cfg, rl.current)
+	if err != nil {
+		slog.Error("重新加载配置失败，继续使用当前配置", "trigger", trigger, "error", err)
+		return
+	}
+
+	utils.SetLogLevel(level)
+	rl.resolver.SetTrusted(trusted)
+	rl.live.store(svc.handler)
+	old := rl.current
+	rl.current = svc
+	old.proxy.CloseIdleConnections()
+
+	for _, change := range changes {
+		if needsRestart(change.Field) {
+			slog.Warn("配置已修改，需要重启服务才能生效", "field", change.Field, "old", change.Old, "new", change.New)
+		} else {
+			slog.Info("配置已修改", "field", change.Field, "old", change.Old, "new", change.New)
+		}
+	}
+	slog.Info("配置已重新加载", "trigger", trigger, "changes", len(changes))
+}
+
+// needsRestart 判断配置项修改后是否需要重启服务
+func needsRestart(field string) bool {
+	for _, prefix := range restartFields {
+		if field == prefix || strings.HasPrefix(field, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
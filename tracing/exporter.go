@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ExporterOTLP   = "otlp"   // 通过OTLP/HTTP发送JSON格式的数据
+	ExporterStdout = "stdout" // 每批span输出一行OTLP JSON，便于本地调试
+)
+
+// scopeName 导出数据中的instrumentation scope名称
+const scopeName = "dl-proxy"
+
+// Exporter 将一批span发送到追踪后端
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+}
+
+// NewExporter 根据类型创建导出器
+func NewExporter(kind, endpoint string, headers map[string]string, timeout time.Duration, serviceName string, stdout io.Writer) (Exporter, error) {
+	switch kind {
+	case "", ExporterOTLP:
+		if endpoint == "" {
+			return nil, fmt.Errorf("未配置OTLP导出地址")
+		}
+		return &OTLPExporter{
+			endpoint:    endpoint,
+			headers:     headers,
+			serviceName: serviceName,
+			client:      &http.Client{Timeout: timeout},
+		}, nil
+	case ExporterStdout:
+		return &writerExporter{w: stdout, serviceName: serviceName}, nil
+	default:
+		return nil, fmt.Errorf("未知的追踪导出器: %s", kind)
+	}
+}
+
+// OTLPExporter 以OTLP/HTTP JSON格式发送span，兼容OpenTelemetry Collector、Jaeger和Tempo等
+type OTLPExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+}
+
+// ExportSpans 实现Exporter接口
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(newPayload(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("追踪后端返回 %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// writerExporter 将每批span作为一行JSON写入w
+type writerExporter struct {
+	w           io.Writer
+	serviceName string
+	mu          sync.Mutex
+}
+
+// ExportSpans 实现Exporter接口
+func (e *writerExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(newPayload(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(body, '\n'))
+	return err
+}
+
+// 以下类型对应OTLP的JSON编码，字段说明见opentelemetry-proto
+
+type otlpPayload struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    statusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // 64位整数按字符串编码
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// newPayload 将span转换为OTLP JSON结构
+func newPayload(serviceName string, spans []*Span) otlpPayload {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.sc.TraceID.String(),
+			SpanID:            s.sc.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: s.status, Message: s.statusMsg},
+		}
+		if s.parent != (SpanID{}) {
+			span.ParentSpanID = s.parent.String()
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: attr.key, Value: anyValue(attr.value)})
+		}
+		s.mu.Unlock()
+		converted = append(converted, span)
+	}
+
+	return otlpPayload{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: anyValue(serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: converted,
+		}},
+	}}}
+}
+
+// anyValue 按值的类型转换为OTLP属性值，无法识别的类型按字符串输出
+func anyValue(v any) otlpAnyValue {
+	var i int64
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case float32:
+		f := float64(v)
+		return otlpAnyValue{DoubleValue: &f}
+	case int:
+		i = int64(v)
+	case int32:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint32:
+		i = int64(v)
+	case time.Duration:
+		// 耗时按毫秒输出，便于在追踪界面中阅读
+		f := float64(v) / float64(time.Millisecond)
+		return otlpAnyValue{DoubleValue: &f}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+	s := strconv.FormatInt(i, 10)
+	return otlpAnyValue{IntValue: &s}
+}
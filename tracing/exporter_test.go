@@ -0,0 +1,283 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 以下类型按opentelemetry-proto的JSON编码独立定义，用于检查导出器实际发送的字段名和类型
+
+type receivedPayload struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []receivedKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []receivedSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type receivedSpan struct {
+	TraceID           string             `json:"traceId"`
+	SpanID            string             `json:"spanId"`
+	ParentSpanID      string             `json:"parentSpanId"`
+	Name              string             `json:"name"`
+	Kind              int                `json:"kind"`
+	StartTimeUnixNano string             `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string             `json:"endTimeUnixNano"`
+	Attributes        []receivedKeyValue `json:"attributes"`
+	Status            struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type receivedKeyValue struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// collector 模拟OTLP/HTTP接收端，记录收到的每个请求
+type collector struct {
+	server *httptest.Server
+	status int
+
+	mu       sync.Mutex
+	bodies   [][]byte
+	payloads []receivedPayload
+	headers  []http.Header
+}
+
+func newCollector(t *testing.T, status int) *collector {
+	c := &collector{status: status}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			t.Errorf("收到 %s %s，应为 POST /v1/traces", r.Method, r.URL.Path)
+		}
+
+		var payload receivedPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("解析导出数据失败: %v\n%s", err, body)
+		}
+		c.mu.Lock()
+		c.bodies = append(c.bodies, body)
+		c.payloads = append(c.payloads, payload)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.mu.Unlock()
+
+		w.WriteHeader(c.status)
+		if c.status != http.StatusOK {
+			io.WriteString(w, "collector unavailable\n")
+		}
+	}))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func (c *collector) endpoint() string {
+	return c.server.URL + "/v1/traces"
+}
+
+// spans 返回所有请求中的span
+func (c *collector) spans() []receivedSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []receivedSpan
+	for _, p := range c.payloads {
+		for _, rs := range p.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func newTestExporter(t *testing.T, endpoint string) Exporter {
+	t.Helper()
+	exporter, err := NewExporter(ExporterOTLP, endpoint, map[string]string{"Authorization": "Bearer secret"},
+		5*time.Second, "dl-proxy-test", nil)
+	if err != nil {
+		t.Fatalf("创建导出器失败: %v", err)
+	}
+	return exporter
+}
+
+func shutdown(t *testing.T, tracer *Tracer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown失败: %v", err)
+	}
+}
+
+func attr(t *testing.T, attrs []receivedKeyValue, key string) string {
+	t.Helper()
+	for _, a := range attrs {
+		if a.Key == key {
+			return string(a.Value)
+		}
+	}
+	t.Fatalf("缺少属性%s", key)
+	return ""
+}
+
+func TestOTLPExporterPayload(t *testing.T) {
+	c := newCollector(t, http.StatusOK)
+	tracer := Setup(newTestExporter(t, c.endpoint()), 1)
+
+	ctx, root := Start(context.Background(), "GET /download", KindServer)
+	root.SetAttributes("url.full", "https://example.com/a.zip", "http.response.status_code", 200,
+		"bytes", int64(1<<40), "cached", false, "ratio", 0.5)
+	_, child := Start(ctx, "upstream GET", KindClient)
+	child.SetError(errors.New("connection refused"))
+	child.End()
+	root.End()
+
+	// 定时导出的间隔远大于测试时间，span只能由Shutdown导出
+	shutdown(t, tracer)
+
+	if len(c.headers) != 1 {
+		t.Fatalf("收到%d个导出请求，应为1个", len(c.headers))
+	}
+	if ct := c.headers[0].Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type为%q，应为application/json", ct)
+	}
+	if auth := c.headers[0].Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization为%q，应为配置的头", auth)
+	}
+
+	payload := c.payloads[0]
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("导出数据结构不正确: %s", c.bodies[0])
+	}
+	if got := attr(t, payload.ResourceSpans[0].Resource.Attributes, "service.name"); got != `{"stringValue":"dl-proxy-test"}` {
+		t.Errorf("service.name为%s", got)
+	}
+	if name := payload.ResourceSpans[0].ScopeSpans[0].Scope.Name; name != scopeName {
+		t.Errorf("scope名称为%q，应为%q", name, scopeName)
+	}
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("收到%d个span，应为2个", len(spans))
+	}
+	// span按结束顺序导出
+	gotChild, gotRoot := spans[0], spans[1]
+
+	// 父子关系
+	if gotRoot.TraceID != root.SpanContext().TraceID.String() || len(gotRoot.TraceID) != 32 {
+		t.Errorf("根span的traceId为%q，应为%s", gotRoot.TraceID, root.SpanContext().TraceID)
+	}
+	if gotRoot.SpanID != root.SpanContext().SpanID.String() || len(gotRoot.SpanID) != 16 {
+		t.Errorf("根span的spanId为%q，应为%s", gotRoot.SpanID, root.SpanContext().SpanID)
+	}
+	if gotRoot.ParentSpanID != "" {
+		t.Errorf("根span不应有parentSpanId，实际为%q", gotRoot.ParentSpanID)
+	}
+	if gotChild.TraceID != gotRoot.TraceID {
+		t.Errorf("子span的traceId为%q，应与根span相同(%s)", gotChild.TraceID, gotRoot.TraceID)
+	}
+	if gotChild.ParentSpanID != gotRoot.SpanID {
+		t.Errorf("子span的parentSpanId为%q，应为根span的spanId(%s)", gotChild.ParentSpanID, gotRoot.SpanID)
+	}
+	if gotChild.SpanID == gotRoot.SpanID {
+		t.Errorf("子span与根span的spanId相同")
+	}
+
+	// 名称、类型和时间
+	if gotRoot.Name != "GET /download" || gotRoot.Kind != int(KindServer) {
+		t.Errorf("根span为%q(kind=%d)", gotRoot.Name, gotRoot.Kind)
+	}
+	if gotChild.Name != "upstream GET" || gotChild.Kind != int(KindClient) {
+		t.Errorf("子span为%q(kind=%d)", gotChild.Name, gotChild.Kind)
+	}
+	for _, s := range spans {
+		start, err1 := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+		end, err2 := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+		if err1 != nil || err2 != nil || start <= 0 || end < start {
+			t.Errorf("span %q的时间无效: %s - %s", s.Name, s.StartTimeUnixNano, s.EndTimeUnixNano)
+		}
+	}
+
+	// 属性编码，64位整数按字符串编码
+	for key, want := range map[string]string{
+		"url.full":                  `{"stringValue":"https://example.com/a.zip"}`,
+		"http.response.status_code": `{"intValue":"200"}`,
+		"bytes":                     `{"intValue":"1099511627776"}`,
+		"cached":                    `{"boolValue":false}`,
+		"ratio":                     `{"doubleValue":0.5}`,
+	} {
+		if got := attr(t, gotRoot.Attributes, key); got != want {
+			t.Errorf("属性%s编码为%s，应为%s", key, got, want)
+		}
+	}
+
+	// 状态
+	if gotRoot.Status.Code != 0 || gotRoot.Status.Message != "" {
+		t.Errorf("根span的状态为%d %q，应为未设置", gotRoot.Status.Code, gotRoot.Status.Message)
+	}
+	if gotChild.Status.Code != int(statusError) || gotChild.Status.Message != "connection refused" {
+		t.Errorf("子span的状态为%d %q，应为错误", gotChild.Status.Code, gotChild.Status.Message)
+	}
+	if !bytes.Contains(c.bodies[0], []byte(`"status":{}`)) {
+		t.Errorf("未设置的状态应编码为空对象: %s", c.bodies[0])
+	}
+}
+
+func TestOTLPExporterErrorStatus(t *testing.T) {
+	c := newCollector(t, http.StatusServiceUnavailable)
+	exporter := newTestExporter(t, c.endpoint())
+
+	err := exporter.ExportSpans(context.Background(), nil)
+	if err == nil {
+		t.Fatal("接收端返回503时应返回错误")
+	}
+	if !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "collector unavailable") {
+		t.Errorf("错误信息应包含状态码和响应内容，实际为: %v", err)
+	}
+}
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	c := newCollector(t, http.StatusOK)
+	tracer := Setup(newTestExporter(t, c.endpoint()), 1)
+
+	// 超过一个批次，Shutdown需要分多次导出
+	const n = maxBatchSize + 100
+	for i := 0; i < n; i++ {
+		_, s := Start(context.Background(), "span", KindInternal)
+		s.End()
+	}
+	shutdown(t, tracer)
+
+	if got := len(c.spans()); got != n {
+		t.Errorf("Shutdown后收到%d个span，应为%d个", got, n)
+	}
+	if len(c.payloads) < 2 {
+		t.Errorf("收到%d个导出请求，超过maxBatchSize时应分批导出", len(c.payloads))
+	}
+
+	// Shutdown之后不再创建span
+	if _, s := Start(context.Background(), "after", KindInternal); s != nil {
+		t.Error("Shutdown之后不应再创建span")
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("重复调用Shutdown失败: %v", err)
+	}
+}
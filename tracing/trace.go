@@ -0,0 +1,241 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind span的类型，取值与OTLP一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1 // 服务内部的处理步骤
+	KindServer   SpanKind = 2 // 处理客户端请求
+	KindClient   SpanKind = 3 // 向上游发起的请求
+)
+
+// statusCode span的状态，取值与OTLP一致
+type statusCode int
+
+const (
+	statusUnset statusCode = 0
+	statusError statusCode = 2
+)
+
+// TraceID 16字节的追踪标识
+type TraceID [16]byte
+
+// SpanID 8字节的span标识
+type SpanID [8]byte
+
+// String 返回十六进制表示
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// String 返回十六进制表示
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext 跨进程传递的span标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// valid 判断标识是否有效，全零的标识无效
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// attribute span的属性
+type attribute struct {
+	key   string
+	value any
+}
+
+// Span 一次操作的计时记录
+// 所有方法都可以在nil上调用，未启用追踪时调用方无需判断
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []attribute
+	status    statusCode
+	statusMsg string
+	ended     bool
+}
+
+// SetAttributes 按key、value交替的形式设置属性
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		s.attrs = append(s.attrs, attribute{key: key, value: kv[i+1]})
+	}
+}
+
+// SetError 将span标记为失败，err为nil时不做任何处理
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetStatusError(err.Error())
+}
+
+// SetStatusError 将span标记为失败并记录原因
+func (s *Span) SetStatusError(msg string) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = statusError
+	s.statusMsg = msg
+}
+
+// IsRecording 判断span是否会被导出
+func (s *Span) IsRecording() bool {
+	return s != nil && s.sc.Sampled
+}
+
+// SpanContext 返回span的标识
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// End 结束span，重复调用只有第一次生效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// spanKey 上下文中保存当前span的键
+type spanKey struct{}
+
+// remoteParentKey 上下文中保存上游调用方span标识的键
+type remoteParentKey struct{}
+
+// ContextWithRemoteParent 将调用方传来的span标识保存到上下文，之后创建的span作为其子span
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+// SpanFromContext 返回上下文中的当前span，不存在时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// provider 全局的追踪器，未启用追踪时为nil
+var provider atomic.Pointer[Tracer]
+
+// Start 创建span并返回包含该span的上下文
+// 未启用追踪时返回原上下文和nil
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := provider.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+
+	// 优先使用本进程内的父span，其次使用调用方传来的span标识
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	if parent.valid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sampleRatio >= 1 || mrand.Float64() < t.sampleRatio
+	}
+	rand.Read(s.sc.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// traceparentHeader W3C Trace Context使用的头
+const traceparentHeader = "traceparent"
+
+// Inject 将上下文中当前span的标识写入traceparent头
+func Inject(ctx context.Context, header http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.sc.Sampled {
+		flags = "01"
+	}
+	header.Set(traceparentHeader, "00-"+s.sc.TraceID.String()+"-"+s.sc.SpanID.String()+"-"+flags)
+}
+
+// Extract 解析请求中的traceparent头
+func Extract(header http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header.Get(traceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	return sc, sc.valid()
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/yourusername/proxy-service/metrics"
+)
+
+const (
+	queueSize     = 2048            // 等待导出的span上限，超出后丢弃
+	maxBatchSize  = 512             // 每次导出的最大span数
+	flushInterval = 5 * time.Second // 定时导出的间隔
+)
+
+// 因队列已满被丢弃的span数
+var droppedSpans = metrics.NewCounter(
+	"dl_proxy_tracing_dropped_spans_total",
+	"导出队列已满而被丢弃的span数")
+
+// Tracer 收集已结束的span并批量导出
+type Tracer struct {
+	sampleRatio float64
+	exporter    Exporter
+
+	queue    chan *Span
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Setup 创建追踪器并设为全局追踪器
+// sampleRatio为没有上游追踪信息时的采样比例，有上游信息时沿用上游的采样决定
+func Setup(exporter Exporter, sampleRatio float64) *Tracer {
+	t := &Tracer{
+		sampleRatio: sampleRatio,
+		exporter:    exporter,
+		queue:       make(chan *Span, queueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	provider.Store(t)
+	return t
+}
+
+// Shutdown 停止接收新的span，导出队列中剩余的span
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	provider.CompareAndSwap(t, nil)
+	t.stopOnce.Do(func() { close(t.stop) })
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue 将已结束的span加入导出队列，队列已满时丢弃
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		droppedSpans.Inc()
+	}
+}
+
+// run 按批次或定时导出span
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			slog.Warn("导出追踪数据失败", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = make([]*Span, 0, maxBatchSize)
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			// 导出队列中剩余的span
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+					if len(batch) >= maxBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ValidToken 校验请求携带的访问令牌
+// 令牌可以通过 Authorization: Bearer <token> 头或token查询参数传递
+func ValidToken(r *http.Request, token string) bool {
+	got := r.URL.Query().Get("token")
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = strings.TrimSpace(auth)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// clientIPKey 请求上下文中保存客户端IP的键
+type clientIPKey struct{}
+
+// ClientIPResolver 根据可信代理配置解析客户端真实IP
+// 只有直接连接方是可信代理时才会读取Forwarded、X-Forwarded-For和X-Real-IP头，
+// 并从右向左跳过可信代理，第一个不可信的地址即为客户端地址
+type ClientIPResolver struct {
+	trusted atomic.Pointer[[]netip.Prefix]
+}
+
+// NewClientIPResolver 创建客户端IP解析器，trustedCIDRs为可信代理的地址段
+func NewClientIPResolver(trustedCIDRs []string) (*ClientIPResolver, error) {
+	trusted, err := ParsePrefixes(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	cr := &ClientIPResolver{}
+	cr.SetTrusted(trusted)
+	return cr, nil
+}
+
+// SetTrusted 替换可信代理列表，用于重新加载配置
+func (cr *ClientIPResolver) SetTrusted(trusted []netip.Prefix) {
+	cr.trusted.Store(&trusted)
+}
+
+// ParsePrefixes 解析CIDR列表，单个IP视为/32或/128
+func ParsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", cidr)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Trusted 判断地址是否为可信代理
+func (cr *ClientIPResolver) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range *cr.trusted.Load() {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedPeer 判断请求的直接连接方是否为可信代理
+func (cr *ClientIPResolver) TrustedPeer(r *http.Request) bool {
+	peer, ok := parseAddr(r.RemoteAddr)
+	return ok && cr.Trusted(peer)
+}
+
+// Resolve 解析请求的客户端IP
+func (cr *ClientIPResolver) Resolve(r *http.Request) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r.RemoteAddr)
+	}
+	if !cr.Trusted(peer) {
+		return peer.String()
+	}
+
+	// 优先使用RFC 7239的Forwarded头
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, value := range xff {
+			for _, hop := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(hop))
+			}
+		}
+	} else if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
+		chain = []string{strings.TrimSpace(xrip)}
+	}
+
+	// 从最近的一跳开始向前查找第一个不可信的地址
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			// 无法解析的地址（如unknown或混淆标识）之前的内容不可信
+			break
+		}
+		client = addr
+		if !cr.Trusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// parseForwarded 提取Forwarded头中所有for参数的值
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					chain = append(chain, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return chain
+}
+
+// parseAddr 解析可能带有端口或方括号的IP地址
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// remoteHost 去掉地址中的端口
+func remoteHost(remoteAddr string) string {
+	if remoteAddr == "" {
+		return "未知IP"
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// WithClientIP 将解析出的客户端IP保存到请求上下文中
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP 返回请求的客户端IP，未经过解析中间件时使用直接连接方的地址
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
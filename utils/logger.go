@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// logTimeFormat 文本日志的时间格式
+const logTimeFormat = "2006-01-02 15:04:05"
+
+// logLevel 当前的日志级别，重新加载配置时直接修改，无需重建日志记录器
+var logLevel slog.LevelVar
+
+// NewLogger 根据日志级别和格式创建结构化日志记录器
+// format为json时每条日志输出一个JSON对象，为text时输出key=value格式
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	lvl, err := ParseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(lvl)
+	opts := &slog.HandlerOptions{Level: &logLevel}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(contextHandler{slog.NewJSONHandler(w, opts)}), nil
+	case "", "text":
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(slog.TimeKey, a.Value.Time().Format(logTimeFormat))
+			}
+			return a
+		}
+		return slog.New(contextHandler{slog.NewTextHandler(w, opts)}), nil
+	default:
+		return nil, fmt.Errorf("无效的日志格式: %s", format)
+	}
+}
+
+// ParseLogLevel 解析日志级别名称
+func ParseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return lvl, fmt.Errorf("无效的日志级别: %s", level)
+	}
+	return lvl, nil
+}
+
+// SetLogLevel 修改NewLogger创建的日志记录器的级别
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// contextHandler 为使用*Context方法记录的日志自动添加上下文中的请求ID
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle 实现slog.Handler接口
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs 实现slog.Handler接口
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 实现slog.Handler接口
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
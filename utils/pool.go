@@ -2,30 +2,107 @@ package utils
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // BufferPool 实现内存缓冲区池
 type BufferPool struct {
 	pool sync.Pool
+
+	// 使用情况统计
+	gets   atomic.Int64
+	puts   atomic.Int64
+	allocs atomic.Int64
+}
+
+// BufferPoolStats 缓冲区池的使用情况
+type BufferPoolStats struct {
+	Gets   int64 // 获取次数
+	Puts   int64 // 归还次数
+	Allocs int64 // 池中没有可用缓冲区时新分配的次数
 }
 
 // NewBufferPool 创建固定大小的缓冲区池
 func NewBufferPool(size int) *BufferPool {
-	return &BufferPool{
-		pool: sync.Pool{
-			New: func() interface{} {
-				return make([]byte, size)
-			},
-		},
+	p := &BufferPool{}
+	p.pool.New = func() interface{} {
+		p.allocs.Add(1)
+		return make([]byte, size)
 	}
+	return p
 }
 
 // Get 从池中获取一个缓冲区
 func (p *BufferPool) Get() []byte {
+	p.gets.Add(1)
 	return p.pool.Get().([]byte)
 }
 
 // Put 将缓冲区放回池中
 func (p *BufferPool) Put(buffer []byte) {
+	p.puts.Add(1)
 	p.pool.Put(buffer)
 }
+
+// Stats 返回缓冲区池的使用情况
+func (p *BufferPool) Stats() BufferPoolStats {
+	return BufferPoolStats{
+		Gets:   p.gets.Load(),
+		Puts:   p.puts.Load(),
+		Allocs: p.allocs.Load(),
+	}
+}
+
+// SizedBufferPool 按缓冲区大小分别维护的缓冲区池，用于同时存在多种缓冲区大小的场景
+type SizedBufferPool struct {
+	mu    sync.RWMutex
+	pools map[int]*BufferPool
+}
+
+// NewSizedBufferPool 创建按大小分别维护的缓冲区池
+func NewSizedBufferPool() *SizedBufferPool {
+	return &SizedBufferPool{pools: make(map[int]*BufferPool)}
+}
+
+// pool 返回指定大小的缓冲区池，不存在时创建
+func (p *SizedBufferPool) pool(size int) *BufferPool {
+	p.mu.RLock()
+	bp := p.pools[size]
+	p.mu.RUnlock()
+	if bp != nil {
+		return bp
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bp = p.pools[size]; bp == nil {
+		bp = NewBufferPool(size)
+		p.pools[size] = bp
+	}
+	return bp
+}
+
+// Get 获取指定大小的缓冲区
+func (p *SizedBufferPool) Get(size int) []byte {
+	return p.pool(size).Get()
+}
+
+// Put 按缓冲区的大小放回对应的池中
+func (p *SizedBufferPool) Put(buffer []byte) {
+	p.pool(len(buffer)).Put(buffer)
+}
+
+// Stats 返回所有大小的缓冲区池的使用情况之和
+func (p *SizedBufferPool) Stats() BufferPoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var total BufferPoolStats
+	for _, bp := range p.pools {
+		stats := bp.Stats()
+		total.Gets += stats.Gets
+		total.Puts += stats.Puts
+		total.Allocs += stats.Allocs
+	}
+	return total
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY协议v2的签名
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoHeaderTimeout 读取PROXY协议头的超时时间
+const proxyProtoHeaderTimeout = 5 * time.Second
+
+// ProxyProtoListener 解析负载均衡器发送的PROXY协议头（v1和v2）
+// 只有来自可信代理的连接才会解析协议头，其余连接按普通连接处理
+type ProxyProtoListener struct {
+	net.Listener
+	resolver *ClientIPResolver
+}
+
+// NewProxyProtoListener 包装监听器以支持PROXY协议
+func NewProxyProtoListener(ln net.Listener, resolver *ClientIPResolver) *ProxyProtoListener {
+	return &ProxyProtoListener{Listener: ln, resolver: resolver}
+}
+
+// Accept 接受连接，协议头在首次读取或获取地址时才解析，避免阻塞Accept循环
+func (l *ProxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, ok := parseAddr(conn.RemoteAddr().String())
+	if !ok || !l.resolver.Trusted(peer) {
+		return conn, nil
+	}
+	return &proxyProtoConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		remote: conn.RemoteAddr(),
+	}, nil
+}
+
+// proxyProtoConn 带有PROXY协议头的连接
+type proxyProtoConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+	once   sync.Once
+}
+
+// init 读取并解析PROXY协议头
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		remote, err := readProxyProtoHeader(c.reader)
+		if err != nil {
+			c.err = fmt.Errorf("解析PROXY协议头失败: %v", err)
+			return
+		}
+		if remote != nil {
+			c.remote = remote
+		}
+	})
+}
+
+// Read 实现io.Reader接口，协议头之后的数据原样返回
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr 返回协议头中的源地址
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// readProxyProtoHeader 读取PROXY协议头，LOCAL命令或UNKNOWN协议返回nil地址
+func readProxyProtoHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(proxyProtoV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyProtoV2Signature) {
+		return readProxyProtoV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyProtoV1(r)
+	}
+	return nil, fmt.Errorf("缺少PROXY协议头")
+}
+
+// readProxyProtoV1 解析文本格式的协议头，如 "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n"
+func readProxyProtoV1(r *bufio.Reader) (net.Addr, error) {
+	// 协议规定v1头最长107字节
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1协议头格式无效")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("v1协议头格式无效")
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("v1协议头源地址无效: %s", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("v1协议头源端口无效: %s", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readProxyProtoV2 解析二进制格式的协议头
+func readProxyProtoV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("不支持的v2协议版本: %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL命令表示负载均衡器自身的健康检查，使用连接的实际地址
+	if header[12]&0x0f == 0x00 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("v2协议头长度无效")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("v2协议头长度无效")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+	default:
+		return nil, nil
+	}
+}
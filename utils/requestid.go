@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+)
+
+// requestIDKey 请求上下文中保存请求ID的键
+type requestIDKey struct{}
+
+// WithRequestID 将请求ID保存到上下文中
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 返回上下文中的请求ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// HTTPError 返回纯文本错误响应，响应体附带请求ID以便用户反馈问题时提供
+func HTTPError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	if id := RequestID(r.Context()); id != "" {
+		msg += "\n请求ID: " + id
+	}
+	http.Error(w, msg, status)
+}
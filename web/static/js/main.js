@@ -8,6 +8,17 @@ document.addEventListener('DOMContentLoaded', () => {
     const directLink = document.getElementById('direct-link');
     const themeToggle = document.getElementById('theme-toggle');
     const tiltCard = document.querySelector('.tilt-card');
+    const progressPanel = document.getElementById('progress-panel');
+    const progressStatus = document.getElementById('progress-status');
+    const progressPercent = document.getElementById('progress-percent');
+    const progressFill = document.getElementById('progress-fill');
+    const progressBytes = document.getElementById('progress-bytes');
+    const progressSpeed = document.getElementById('progress-speed');
+    const progressConns = document.getElementById('progress-conns');
+    
+    // 当前生成链接对应的目标URL和进度订阅
+    let currentTargetUrl = '';
+    let progressSource = null;
     
     // 主题切换功能 - 增加基于时间的自动切换
     function setThemeBasedOnTime() {
@@ -155,6 +166,11 @@ document.addEventListener('DOMContentLoaded', () => {
                 const urlPath = new URL(targetUrl).pathname;
                 const fileName = urlPath.substring(urlPath.lastIndexOf('/') + 1);
                 
+                // 切换链接时停止之前的进度订阅
+                currentTargetUrl = targetUrl;
+                stopProgress();
+                progressPanel.style.display = 'none';
+                
                 // 构建代理URL
                 const proxyUrl = `${window.location.origin}/${targetUrl}`;
                 proxyUrlInput.value = proxyUrl;
@@ -177,6 +193,105 @@ document.addEventListener('DOMContentLoaded', () => {
         }, 800); // 模拟处理延迟
     });
     
+    // 格式化字节数
+    function formatBytes(bytes) {
+        if (bytes < 0) return '未知';
+        const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+        let i = 0;
+        while (bytes >= 1024 && i < units.length - 1) {
+            bytes /= 1024;
+            i++;
+        }
+        return `${bytes.toFixed(i === 0 ? 0 : 2)} ${units[i]}`;
+    }
+    
+    // 下载结束时的提示文字
+    const endMessages = {
+        completed: '下载完成',
+        cancelled: '下载已取消',
+        aborted: '下载已被管理员中断',
+        failed: '下载出错'
+    };
+    
+    // 更新进度面板
+    function renderProgress(status) {
+        const known = status.total_bytes > 0;
+        progressFill.classList.toggle('indeterminate', !known && status.state === 'active');
+        progressFill.style.width = known ? `${Math.min(status.percent || 0, 100)}%` : '';
+        progressPercent.textContent = known ? `${(status.percent || 0).toFixed(1)}%` : '';
+        progressBytes.textContent = known
+            ? `${formatBytes(status.bytes)} / ${formatBytes(status.total_bytes)}`
+            : formatBytes(status.bytes);
+        progressSpeed.textContent = `${formatBytes(status.speed_bps)}/s`;
+        progressConns.textContent = `${status.active_conns} 个连接`;
+        
+        if (status.state === 'active') {
+            progressStatus.textContent = '服务器正在传输...';
+        } else if (status.state === 'paused') {
+            progressStatus.textContent = '等待客户端继续请求...';
+        } else {
+            progressStatus.textContent = endMessages[status.state] || status.state;
+        }
+    }
+    
+    // 停止进度订阅
+    function stopProgress() {
+        if (progressSource) {
+            progressSource.close();
+            progressSource = null;
+        }
+    }
+    
+    // 订阅服务器推送的下载进度
+    function startProgress(targetUrl) {
+        stopProgress();
+        
+        progressPanel.classList.remove('done', 'failed');
+        progressPanel.style.display = 'block';
+        progressStatus.textContent = '等待下载开始...';
+        progressPercent.textContent = '';
+        progressFill.style.width = '0';
+        progressFill.classList.remove('indeterminate');
+        progressBytes.textContent = '';
+        progressSpeed.textContent = '';
+        progressConns.textContent = '';
+        
+        const source = new EventSource(`/api/progress?url=${encodeURIComponent(targetUrl)}`);
+        progressSource = source;
+        
+        source.addEventListener('progress', (e) => {
+            renderProgress(JSON.parse(e.data));
+        });
+        
+        source.addEventListener('end', (e) => {
+            const status = JSON.parse(e.data);
+            renderProgress(status);
+            progressPanel.classList.add(status.state === 'completed' ? 'done' : 'failed');
+            stopProgress();
+        });
+        
+        source.addEventListener('notfound', () => {
+            progressStatus.textContent = '未检测到下载，请确认下载是否已开始';
+            progressPanel.classList.add('failed');
+            stopProgress();
+        });
+        
+        // 连接中断时不自动重连，避免重复订阅
+        source.onerror = () => {
+            if (progressSource === source) {
+                progressStatus.textContent = '进度连接已断开';
+                stopProgress();
+            }
+        };
+    }
+    
+    // 点击下载链接后显示进度面板
+    directLink.addEventListener('click', () => {
+        if (currentTargetUrl) {
+            startProgress(currentTargetUrl);
+        }
+    });
+    
     // 复制链接到剪贴板
     copyBtn.addEventListener('click', () => {
         proxyUrlInput.select();